@@ -0,0 +1,338 @@
+package yagolib
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// ConfigCompletionFlag is the name of hidden command line flag which makes LoadConfig print
+// the shell completion script: 'myapp --completion bash' (see WriteCompletionScript).
+// Set the variable to "" to disable the flag.
+var ConfigCompletionFlag = "completion"
+
+// completionLevel describes the flags and arguments available after the command 'command'
+// ("" for the application itself, "remote add" for nested command).
+type completionLevel struct {
+	command     string
+	flags       []*cmdLineFlag // including the flags of enclosing commands
+	commands    []*cmdLineCommand
+	positionals []*cmdLineFlag
+}
+
+// getCompletionLevels returns the levels of command tree of the structure.
+func getCompletionLevels(structValue reflect.Value) []*completionLevel {
+	var levels []*completionLevel
+	var walk func(structValue reflect.Value, pathPrefix, command string, inherited []*cmdLineFlag)
+	walk = func(structValue reflect.Value, pathPrefix, command string, inherited []*cmdLineFlag) {
+		level := &completionLevel{
+			command:     command,
+			flags:       append(getCmdLineFlags(structValue, "", pathPrefix), inherited...),
+			commands:    getCmdLineCommands(structValue, pathPrefix),
+			positionals: getCmdLinePositionals(structValue, pathPrefix),
+		}
+		levels = append(levels, level)
+		for _, c := range level.commands {
+			walk(reflect.New(c.value.Type().Elem()).Elem(), c.path+".", strings.TrimSpace(command+" "+c.name),
+				level.flags)
+		}
+	}
+	walk(structValue, "", "", nil)
+	return levels
+}
+
+// completionValues returns the values of field listed by 'oneof' validation rule.
+func (f *cmdLineFlag) completionValues() []string {
+	return getOneOfValues(f.tag)
+}
+
+// completionPath returns "file" or "dir" if the field is tagged `path:"file"` or `path:"dir"`
+// (empty value means "file"), otherwise empty string.
+func (f *cmdLineFlag) completionPath() string {
+	kind, ok := f.tag.Lookup("path")
+	if !ok {
+		return ""
+	}
+	if kind != "dir" {
+		kind = "file"
+	}
+	return kind
+}
+
+// names returns all command line forms of the flag: "--verbose", "--no-verbose", "-v".
+func (f *cmdLineFlag) names() []string {
+	names := []string{"--" + f.long}
+	if f.isBool() {
+		names = append(names, "--no-"+f.long)
+	}
+	if f.short != "" {
+		names = append(names, "-"+f.short)
+	}
+	return names
+}
+
+// WriteCompletionScript writes the completion script of 'shell' ("bash", "zsh" or "fish")
+// for application 'appName' which command line is parsed into the structure pointed to by 'structPtr'.
+// The script completes the flags, subcommands, the values listed by 'oneof' validation rule
+// and the paths for the fields (flags or positional arguments) tagged `path:"file"` or `path:"dir"`:
+//
+//	type Config struct {
+//		Mode   string   `validate:"oneof=dev|prod"`
+//		Output string   `path:"dir"`
+//		Inputs []string `positional:"" path:"file"`
+//	}
+//
+// LoadConfig prints the script when the command line has '--completion <shell>' flag
+// (see ConfigCompletionFlag):
+//
+//	source <(myapp --completion bash)
+//	myapp --completion fish > ~/.config/fish/completions/myapp.fish
+func WriteCompletionScript(w io.Writer, shell, appName string, structPtr interface{}) error {
+	if structPtr == nil || reflect.TypeOf(structPtr).Kind() != reflect.Ptr ||
+		reflect.TypeOf(structPtr).Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("'structPtr' must be pointer to structure")
+	}
+	levels := getCompletionLevels(reflect.New(reflect.TypeOf(structPtr).Elem()).Elem())
+	var script string
+	switch shell {
+	case "bash":
+		script = getBashCompletion(appName, levels)
+	case "zsh":
+		script = getZshCompletion(appName, levels)
+	case "fish":
+		script = getFishCompletion(appName, levels)
+	default:
+		return fmt.Errorf("Shell '%v' is not supported, use 'bash', 'zsh' or 'fish'", shell)
+	}
+	_, err := io.WriteString(w, script)
+	return err
+}
+
+var completionFuncNameRegexp = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// getCompletionFuncName returns the name of shell function for application 'appName'.
+func getCompletionFuncName(appName string) string {
+	return "_" + completionFuncNameRegexp.ReplaceAllString(appName, "_")
+}
+
+// shellQuote returns 's' in single quotes.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// getCompletionCommandCases returns the patterns of shell 'case' matching "<command>:<subcommand>" words.
+func getCompletionCommandCases(levels []*completionLevel) []string {
+	var cases []string
+	for _, level := range levels {
+		for _, c := range level.commands {
+			cases = append(cases, shellQuote(level.command+":"+c.name))
+		}
+	}
+	return cases
+}
+
+// completionWords returns the words of level: the flags, the subcommands and the values of positional argument.
+// 'kind' is the kind of paths completed for positional argument.
+func (level *completionLevel) completionWords() (flags, words []string, kind string) {
+	for _, f := range level.flags {
+		flags = append(flags, f.names()...)
+	}
+	flags = append(flags, "--help", "-h")
+	for _, c := range level.commands {
+		words = append(words, c.name)
+	}
+	if len(level.positionals) > 0 {
+		words = append(words, level.positionals[0].completionValues()...)
+		kind = level.positionals[0].completionPath()
+	}
+	return flags, words, kind
+}
+
+func getBashCompletion(appName string, levels []*completionLevel) string {
+	funcName := getCompletionFuncName(appName)
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# bash completion for %v\n", appName)
+	fmt.Fprintf(&sb, "%v() {\n", funcName)
+	sb.WriteString("    local cur=\"${COMP_WORDS[COMP_CWORD]}\" prev=\"${COMP_WORDS[COMP_CWORD-1]}\"\n")
+	sb.WriteString("    local cmd=\"\" flags=\"\" words=\"\" kind=\"\" i\n")
+	if cases := getCompletionCommandCases(levels); len(cases) > 0 {
+		sb.WriteString("    for ((i = 1; i < COMP_CWORD; i++)); do\n")
+		sb.WriteString("        case \"$cmd:${COMP_WORDS[i]}\" in\n")
+		fmt.Fprintf(&sb, "            %v) cmd=\"${cmd:+$cmd }${COMP_WORDS[i]}\" ;;\n", strings.Join(cases, "|"))
+		sb.WriteString("        esac\n")
+		sb.WriteString("    done\n")
+	}
+	sb.WriteString("    case \"$cmd:$prev\" in\n")
+	writeValueCases(&sb, levels, func(f *cmdLineFlag) string {
+		switch {
+		case f.completionPath() == "dir":
+			return "COMPREPLY=($(compgen -d -- \"$cur\")); return"
+		case f.completionPath() == "file":
+			return "COMPREPLY=($(compgen -f -- \"$cur\")); return"
+		case len(f.completionValues()) > 0:
+			return fmt.Sprintf("COMPREPLY=($(compgen -W %v -- \"$cur\")); return",
+				shellQuote(strings.Join(f.completionValues(), " ")))
+		}
+		return "return"
+	})
+	sb.WriteString("    esac\n")
+	sb.WriteString("    case \"$cmd\" in\n")
+	for _, level := range levels {
+		flags, words, kind := level.completionWords()
+		fmt.Fprintf(&sb, "        %v) flags=%v; words=%v; kind=%v ;;\n", shellQuote(level.command),
+			shellQuote(strings.Join(flags, " ")), shellQuote(strings.Join(words, " ")), shellQuote(kind))
+	}
+	sb.WriteString("    esac\n")
+	sb.WriteString("    if [[ \"$cur\" == -* ]]; then\n")
+	sb.WriteString("        COMPREPLY=($(compgen -W \"$flags\" -- \"$cur\"))\n")
+	sb.WriteString("        return\n")
+	sb.WriteString("    fi\n")
+	sb.WriteString("    COMPREPLY=($(compgen -W \"$words\" -- \"$cur\"))\n")
+	sb.WriteString("    case \"$kind\" in\n")
+	sb.WriteString("        file) COMPREPLY+=($(compgen -f -- \"$cur\")) ;;\n")
+	sb.WriteString("        dir) COMPREPLY+=($(compgen -d -- \"$cur\")) ;;\n")
+	sb.WriteString("    esac\n")
+	sb.WriteString("}\n")
+	fmt.Fprintf(&sb, "complete -o filenames -F %v %v\n", funcName, appName)
+	return sb.String()
+}
+
+func getZshCompletion(appName string, levels []*completionLevel) string {
+	funcName := getCompletionFuncName(appName)
+	quoteAll := func(list []string) string {
+		quoted := make([]string, len(list))
+		for i, s := range list {
+			quoted[i] = shellQuote(s)
+		}
+		return strings.Join(quoted, " ")
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "#compdef %v\n", appName)
+	fmt.Fprintf(&sb, "%v() {\n", funcName)
+	sb.WriteString("    local cur=\"${words[CURRENT]}\" prev=\"${words[CURRENT-1]}\" cmd=\"\" kind=\"\" i\n")
+	sb.WriteString("    local -a flags args\n")
+	if cases := getCompletionCommandCases(levels); len(cases) > 0 {
+		sb.WriteString("    for ((i = 2; i < CURRENT; i++)); do\n")
+		sb.WriteString("        case \"$cmd:${words[i]}\" in\n")
+		fmt.Fprintf(&sb, "            %v) cmd=\"${cmd:+$cmd }${words[i]}\" ;;\n", strings.Join(cases, "|"))
+		sb.WriteString("        esac\n")
+		sb.WriteString("    done\n")
+	}
+	sb.WriteString("    case \"$cmd:$prev\" in\n")
+	writeValueCases(&sb, levels, func(f *cmdLineFlag) string {
+		switch {
+		case f.completionPath() == "dir":
+			return "_files -/; return"
+		case f.completionPath() == "file":
+			return "_files; return"
+		case len(f.completionValues()) > 0:
+			return fmt.Sprintf("compadd -- %v; return", quoteAll(f.completionValues()))
+		}
+		return "return"
+	})
+	sb.WriteString("    esac\n")
+	sb.WriteString("    case \"$cmd\" in\n")
+	for _, level := range levels {
+		flags, words, kind := level.completionWords()
+		fmt.Fprintf(&sb, "        %v) flags=(%v); args=(%v); kind=%v ;;\n", shellQuote(level.command),
+			quoteAll(flags), quoteAll(words), shellQuote(kind))
+	}
+	sb.WriteString("    esac\n")
+	sb.WriteString("    if [[ \"$cur\" == -* ]]; then\n")
+	sb.WriteString("        compadd -- $flags\n")
+	sb.WriteString("        return\n")
+	sb.WriteString("    fi\n")
+	sb.WriteString("    compadd -- $args\n")
+	sb.WriteString("    case \"$kind\" in\n")
+	sb.WriteString("        file) _files ;;\n")
+	sb.WriteString("        dir) _files -/ ;;\n")
+	sb.WriteString("    esac\n")
+	sb.WriteString("}\n")
+	fmt.Fprintf(&sb, "compdef %v %v\n", funcName, appName)
+	return sb.String()
+}
+
+// writeValueCases writes the cases of shell 'case' statement matching "<command>:<flag>" which complete
+// the values of flags. 'action' returns the shell code completing the value of flag.
+func writeValueCases(sb *strings.Builder, levels []*completionLevel, action func(f *cmdLineFlag) string) {
+	for _, level := range levels {
+		for _, f := range level.flags {
+			if f.isBool() {
+				continue
+			}
+			patterns := []string{shellQuote(level.command + ":--" + f.long)}
+			if f.short != "" {
+				patterns = append(patterns, shellQuote(level.command+":-"+f.short))
+			}
+			fmt.Fprintf(sb, "        %v) %v ;;\n", strings.Join(patterns, "|"), action(f))
+		}
+	}
+}
+
+func getFishCompletion(appName string, levels []*completionLevel) string {
+	funcName := getCompletionFuncName(appName)
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# fish completion for %v\n", appName)
+	fmt.Fprintf(&sb, "function %v_command\n", funcName)
+	sb.WriteString("    set -l cmd ''\n")
+	if cases := getCompletionCommandCases(levels); len(cases) > 0 {
+		sb.WriteString("    for w in (commandline -opc)[2..-1]\n")
+		sb.WriteString("        switch \"$cmd:$w\"\n")
+		fmt.Fprintf(&sb, "            case %v\n", strings.Join(cases, " "))
+		sb.WriteString("                set cmd (string trim -- \"$cmd $w\")\n")
+		sb.WriteString("        end\n")
+		sb.WriteString("    end\n")
+	}
+	sb.WriteString("    test \"$cmd\" = \"$argv[1]\"\n")
+	sb.WriteString("end\n")
+	fmt.Fprintf(&sb, "complete -c %v -f\n", appName)
+	for _, level := range levels {
+		prefix := fmt.Sprintf("complete -c %v -n \"%v_command '%v'\"", appName, funcName, level.command)
+		for _, f := range append(append([]*cmdLineFlag{}, level.flags...), &cmdLineFlag{long: "help", short: "h",
+			help: "Show this help", value: reflect.ValueOf(true)}) {
+			line := prefix + " -l " + f.long
+			if f.short != "" && len([]rune(f.short)) == 1 {
+				line += " -s " + f.short
+			}
+			switch {
+			case f.isBool():
+			case f.completionPath() == "dir":
+				line += " -x -a '(__fish_complete_directories)'"
+			case f.completionPath() == "file":
+				line += " -r -F"
+			case len(f.completionValues()) > 0:
+				line += " -x -a " + shellQuote(strings.Join(f.completionValues(), " "))
+			default:
+				line += " -x"
+			}
+			if f.help != "" {
+				line += " -d " + shellQuote(f.help)
+			}
+			sb.WriteString(line + "\n")
+			if f.isBool() && f.long != "help" {
+				fmt.Fprintf(&sb, "%v -l no-%v\n", prefix, f.long)
+			}
+		}
+		for _, c := range level.commands {
+			line := prefix + " -a " + shellQuote(c.name)
+			if c.help != "" {
+				line += " -d " + shellQuote(c.help)
+			}
+			sb.WriteString(line + "\n")
+		}
+		if len(level.positionals) > 0 {
+			p := level.positionals[0]
+			switch {
+			case p.completionPath() == "dir":
+				sb.WriteString(prefix + " -a '(__fish_complete_directories)'\n")
+			case p.completionPath() == "file":
+				sb.WriteString(prefix + " -F\n")
+			case len(p.completionValues()) > 0:
+				sb.WriteString(prefix + " -a " + shellQuote(strings.Join(p.completionValues(), " ")) + "\n")
+			}
+		}
+	}
+	return sb.String()
+}
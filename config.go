@@ -1,29 +1,52 @@
 package yagolib
 
 import (
-	//"flag"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"reflect"
 	"strings"
-
-	"github.com/BurntSushi/toml"
 )
 
 // LoadConfig loads and parses configuration file(s) in TOML format:
 // https://github.com/toml-lang/toml
+// Files of other formats are decoded by their decoders, see ConfigFormat and RegisterConfigDecoder.
 // Command line arguments may be parsed too if necessary.
 // Configuration will be copied from data sources to the structure pointed by 'config' in the following order:
-// 1. From 'homeConfigName' file in the '~/.config/appName' directory (appName - name of application executable).
-// 2. From 'configPath' file.
-// 3. From command line arguments given by 'cmdLine' string array.
-// So 'homeConfigName' file has the lowest priority. It's settings will be overridden by 'configPath' file.
-// Command line arguments have top priority and will override data from 'configPath' file.
+// 0. The values of `default:"..."` tags (see SetDefaults) and the embedded default files (see WithDefaultsFS).
+// 1. From 'homeConfigName' files found in the config search directories (see ConfigSearchDirs).
+// 2. From 'configPath' file, "-" means the standard input (see ConfigStdinPath).
+// 3. From environment variables named like APPNAME_FIELD_NAME (see ParseEnvToStruct and ConfigEnvPrefix).
+// 4. From command line arguments given by 'cmdLine' string array (see ParseCmdLineToStruct).
+// So 'homeConfigName' files have the lowest priority and command line arguments have top priority.
 // You may omit any config data source, just use empty string for 'homeConfigName'/'configPath' and 'nil' for 'cmdLine'.
 // The fields of 'config' structure must be exported.
-// The names of command line arguments must exactly match the names of 'config' structure fields.
+//
+// LoadConfig is a wrapper of Loader, see Loader and its options for the details and NewLoader for more options.
 func LoadConfig(config interface{}, homeConfigName string, configPath string, cmdLine []string, verbose bool) error {
+	return newCompatLoader(homeConfigName, configPath, cmdLine, verbose).Load(config)
+}
+
+// LoadConfigWithProvenance loads the config like LoadConfig does and returns the provenance:
+// the source of the value of every config field. See PrintProvenance.
+func LoadConfigWithProvenance(config interface{}, homeConfigName string, configPath string, cmdLine []string,
+	verbose bool) (Provenance, error) {
+	return newCompatLoader(homeConfigName, configPath, cmdLine, verbose).LoadWithProvenance(config)
+}
+
+// newCompatLoader returns the Loader doing the same as LoadConfig with the given arguments.
+func newCompatLoader(homeConfigName string, configPath string, cmdLine []string, verbose bool) *Loader {
+	return NewLoader(WithHomeConfig(homeConfigName), WithConfigFile(configPath), WithCmdLine(cmdLine),
+		WithVerbose(verbose))
+}
+
+// load loads the config from all sources.
+func (l *configLoader) load() error {
+	config := l.config
 	if config == nil {
 		return fmt.Errorf("'config' structure pointer is 'nil'")
 	}
@@ -32,108 +55,445 @@ func LoadConfig(config interface{}, homeConfigName string, configPath string, cm
 		return fmt.Errorf("'config' argument is not a pointer to structure. It has type: %v", configType)
 	}
 
-	var errMsg string
+	if err := SetDefaults(config); err != nil {
+		l.addError(err, &ConfigErrorEntry{Kind: ErrConfigValue, Source: SourceDefault})
+	}
+	l.trackDefaults()
 
 	appName := filepath.Base(os.Args[0])
 
-	if homeConfigName != "" {
-		homeConfigPath := filepath.Join("~/.config", appName, homeConfigName)
-		homeConfigPath, err := NormalizePath(homeConfigPath)
-		if (err == nil) && IsFileExists(homeConfigPath) {
-			if verbose {
-				fmt.Printf("Loading config from '%v'\n", homeConfigPath)
-			}
-			if _, err = toml.DecodeFile(homeConfigPath, config); err != nil {
-				errMsg = fmt.Sprintf("Error parsing config file '%v':\n%v\n", homeConfigPath, err)
-				if verbose {
-					fmt.Fprint(os.Stderr, errMsg)
-				}
-			}
-		} else if verbose {
-			fmt.Fprintf(os.Stderr, "Config file '%v' not found\n", homeConfigPath)
+	if shell, ok := findHiddenCmdLineFlag(l.cmdLine, ConfigCompletionFlag, reflect.ValueOf(config).Elem()); ok {
+		if err := WriteCompletionScript(l.getOutput(), shell, appName, config); err != nil {
+			return err
+		}
+		return ErrHelp
+	}
+	if path, ok := findHiddenCmdLineFlag(l.cmdLine, ConfigWriteDefaultFlag, reflect.ValueOf(config).Elem()); ok {
+		force := hasHiddenCmdLineSwitch(l.cmdLine, configForceFlag, reflect.ValueOf(config).Elem())
+		err := writeDefaultConfigFile(path, config, l.getEnvPrefix(), force)
+		if errors.Is(err, os.ErrExist) {
+			err = fmt.Errorf("%w. Use '--%v' to overwrite it", err, configForceFlag)
+		}
+		if err != nil {
+			l.addError(err, &ConfigErrorEntry{Kind: ErrConfigArgument, Source: SourceCmdLine,
+				Name: "--" + ConfigWriteDefaultFlag, Value: path})
+			return l.errs.err()
+		}
+		fmt.Fprintf(l.getOutput(), "Default config is written to '%v'\n", path)
+		return ErrHelp
+	}
+
+	var cmdLine []string
+	l.activeProfile, cmdLine = l.selectProfile()
+	if l.activeProfile != "" {
+		l.infof("Config profile: '%v'", l.activeProfile)
+	}
+
+	l.migrating = len(l.migrations) > 0 || hasDeprecatedFields(configType.Elem(), nil)
+	if hasMapTemplates(configType.Elem(), map[reflect.Type]bool{}) {
+		l.templates = map[string]map[string]interface{}{}
+	}
+	l.entryDefaults = hasEntryDefaults(configType.Elem(), map[reflect.Type]bool{})
+	for _, src := range l.embedded {
+		l.loadEmbedded(src)
+	}
+	if l.homeConfigName != "" && len(l.findConfigFiles()) == 0 {
+		l.warnf("Config file '%v' not found in:\n%v", l.homeConfigName,
+			strings.Join(getConfigSearchPaths(l.searchDirs, l.homeConfigName), "\n"))
+	}
+	for _, path := range l.getConfigFilePaths() {
+		l.loadFile(path)
+	}
+	for _, src := range l.readers {
+		l.loadReader(src)
+	}
+	if l.activeProfile != "" && !l.profileFound {
+		l.addError(fmt.Errorf("profile '%v' is not defined in config files", l.activeProfile),
+			&ConfigErrorEntry{Kind: ErrConfigValue, Source: SourceFile, Name: ConfigProfileKey})
+	}
+
+	if l.envPrefix != "-" {
+		l.loadEnv(l.getEnvPrefix())
+	}
+
+	if cmdLine == nil {
+		resetCmdLineCommands(reflect.ValueOf(config).Elem(), "", nil) // no command is selected
+	} else {
+		set, err := parseCmdLineToStruct(cmdLine, config)
+		if err == ErrHelp {
+			fmt.Fprint(l.getOutput(), GetCmdLineUsage(appName, config))
+			return ErrHelp
+		}
+		if len(set) > 0 {
+			l.infof("Command line parameters:")
+			l.logAppliedValues(set)
+		}
+		l.trackAppliedValues(SourceCmdLine, set)
+		if err != nil {
+			l.addError(err, &ConfigErrorEntry{Kind: ErrConfigArgument, Source: SourceCmdLine})
+		}
+	}
+
+	if l.resolveReferences {
+		l.resolveValues()
+	}
+
+	if err := validateConfig(config); err != nil {
+		l.addError(err, &ConfigErrorEntry{Kind: ErrConfigValidation})
+	}
+	l.trackFinalValues()
+	if l.onFilesLoaded != nil {
+		l.onFilesLoaded(l.files)
+	}
+
+	return l.errs.err()
+}
+
+// validateConfig checks the rules of `validate:"..."` tags and calls Validate method of the config.
+func validateConfig(config interface{}) error {
+	if err := ValidateStruct(config); err != nil {
+		return err
+	}
+	if validator, ok := config.(ConfigValidator); ok {
+		return validator.Validate()
+	}
+	return nil
+}
+
+// findConfigFiles returns the paths of home config files found in the search directories.
+func (ld *Loader) findConfigFiles() []string {
+	if ld.homeConfigName == "" {
+		return nil
+	}
+	return findConfigFiles(ld.searchDirs, ld.searchPolicy, ld.homeConfigName)
+}
+
+// getConfigFilePaths returns the paths of config files read by Loader in the order of loading.
+func (ld *Loader) getConfigFilePaths() []string {
+	paths := ld.findConfigFiles()
+	if ld.configPath != "" {
+		paths = append(paths, ld.configPath)
+	}
+	return paths
+}
+
+// configLoader holds the state of a single Loader.Load call.
+type configLoader struct {
+	*Loader
+	config interface{}
+	files  []string   // config files of OS file system decoded successfully, see WithLoadedFilesFunc
+	prov   Provenance // the origins of field values, also used to locate the errors
+	// the chain of files including the file being loaded, to detect include cycles
+	including []string
+	redacted  []string          // paths of fields which values must not be shown
+	fileData  map[string][]byte // the content of loaded files to show the lines with errors
+	errs      ConfigError       // accumulated errors
+	// the profile selected by option, environment variable or command line
+	activeProfile string
+	profileFound  bool // the active profile is defined in any config file
+	// the config files are migrated: there are migrations or deprecated keys, see migrateMap
+	migrating bool
+	// the config files changed by migration by their paths, see getMigratingDecoder
+	migrated map[string]*migratedConfig
+	// the templates of maps by the paths of map fields, 'nil' if the config has no templates,
+	// see applyMapTemplates
+	templates map[string]map[string]interface{}
+	// the config has the entries of maps or slices of structures with defaults, see setEntryDefaults
+	entryDefaults bool
+}
+
+// addError adds the entries of 'err' if it is ConfigError or ValidationErrors to the errors returned by Load,
+// otherwise 'err' is added as the cause of 'entry'.
+// The entries related to config fields get the location where the field value came from.
+func (l *configLoader) addError(err error, entry *ConfigErrorEntry) {
+	n := len(l.errs.Entries)
+	if fieldErrs, ok := err.(ValidationErrors); ok {
+		for _, fe := range fieldErrs {
+			l.errs.Entries = append(l.errs.Entries, &ConfigErrorEntry{Kind: entry.Kind, Source: entry.Source,
+				Field: fe.Field, Value: fe.Value, Err: fe})
+		}
+	} else {
+		l.errs.add(err, entry)
+	}
+	for _, e := range l.errs.Entries[n:] {
+		l.locateError(e)
+		l.warnf("%v", e)
+	}
+}
+
+// locateError sets the location of the error of config field by the provenance of the field.
+// The error of the field of map or slice entry ("Device[camera].Address") is located by the map or slice.
+func (l *configLoader) locateError(e *ConfigErrorEntry) {
+	if e.Field == "" || e.File != "" || e.Name != "" {
+		return
+	}
+	origin := l.prov[e.Field]
+	for field := e.Field; origin == nil && strings.Contains(field, "["); {
+		field = field[:strings.LastIndex(field, "[")]
+		origin = l.prov[field]
+	}
+	if origin == nil {
+		return
+	}
+	e.Source, e.File, e.Line, e.Column, e.Name = origin.Source, origin.File, origin.Line, origin.Column, origin.Name
+	e.lineText = getTextLine(l.fileData[e.File], e.Line)
+}
+
+// getOutput returns the writer of the output requested by command line, see WithOutput.
+func (l *configLoader) getOutput() io.Writer {
+	if l.output == nil {
+		return ioutil.Discard
+	}
+	return l.output
+}
+
+func (l *configLoader) infof(format string, args ...interface{}) {
+	if l.logger != nil {
+		l.logger.Infof(format, args...)
+	}
+}
+
+func (l *configLoader) warnf(format string, args ...interface{}) {
+	if l.logger != nil {
+		l.logger.Warnf(format, args...)
+	}
+}
+
+// loadFile decodes config file 'path' into the config structure, then the files listed in its
+// 'include' key and the drop-in fragments from '<path>.d' directory (see getDropInFiles).
+// Missing file is not an error, its drop-in fragments are loaded anyway.
+// ConfigStdinPath means the standard input.
+func (l *configLoader) loadFile(path string) {
+	if path == ConfigStdinPath {
+		l.loadReader(stdinSource)
+		return
+	}
+	normPath, err := NormalizePath(path)
+	if err != nil {
+		l.warnf("Config file '%v' not found", path)
+		return
+	}
+	if !IsFileExists(normPath) {
+		if l.strict && path == l.configPath {
+			l.addError(os.ErrNotExist, &ConfigErrorEntry{Kind: ErrConfigNotFound, Source: SourceFile, File: normPath})
+		} else {
+			l.warnf("Config file '%v' not found", normPath)
 		}
+	} else {
+		l.loadFragment(nil, normPath, "")
 	}
+	path = normPath
+	for _, dropIn := range getDropInFiles(nil, path) {
+		l.loadFragment(nil, dropIn, fmt.Sprintf("drop-in of '%v'", path))
+	}
+}
 
-	if configPath != "" {
-		configPath, err := NormalizePath(configPath)
-		if (err == nil) && IsFileExists(configPath) {
-			if verbose {
-				fmt.Printf("Loading config from '%v'\n", configPath)
+// loadEmbedded decodes the files of 'src' matching its patterns and their drop-in fragments
+// (see WithDefaultsFS).
+func (l *configLoader) loadEmbedded(src embeddedSource) {
+	for _, pattern := range src.patterns {
+		matches, err := fs.Glob(src.fsys, pattern)
+		if err != nil {
+			l.addError(err, &ConfigErrorEntry{Kind: ErrConfigSyntax, Source: SourceFile, Name: pattern})
+			continue
+		}
+		if len(matches) == 0 {
+			l.warnf("Embedded config '%v' not found", pattern)
+		}
+		for _, path := range matches {
+			if isConfigDir(src.fsys, path) {
+				continue
 			}
-			if _, err = toml.DecodeFile(configPath, config); err != nil {
-				msg := fmt.Sprintf("Error parsing config file '%v':\n%v\n", configPath, err)
-				errMsg += msg
-				if verbose {
-					fmt.Fprint(os.Stderr, msg)
-				}
+			l.loadFragment(src.fsys, path, "embedded")
+			for _, dropIn := range getDropInFiles(src.fsys, path) {
+				l.loadFragment(src.fsys, dropIn, fmt.Sprintf("embedded drop-in of '%v'", path))
 			}
-		} else if verbose {
-			fmt.Fprintf(os.Stderr, "Config file '%v' not found\n", configPath)
-		}
-	}
-
-	//var flagSet *flag.FlagSet
-	if cmdLine != nil {
-		var tomlStr string
-		/*
-			flagSet = flag.NewFlagSet(appName, flag.ContinueOnError)
-			structValue := reflect.ValueOf(config).Elem()
-			structType := structValue.Type()
-			for i := 0; i < structType.NumField(); i++ {
-				fieldValue := structValue.Field(i)
-				field := structType.Field(i)
-				fieldName := field.Name
-				fieldTag := string(field.Tag)
-				switch fieldValue.Kind() {
-				case reflect.Bool:
-					flagSet.Bool(fieldName, fieldValue.Bool(), fieldTag)
-				case reflect.Float32:
-					flagSet.Float64(fieldName, fieldValue.Float(), fieldTag)
-				case reflect.Float64:
-					flagSet.Float64(fieldName, fieldValue.Float(), fieldTag)
-				case reflect.Int:
-					flagSet.Int(fieldName, int(fieldValue.Int()), fieldTag)
-				case reflect.Int64:
-					flagSet.Int64(fieldName, fieldValue.Int(), fieldTag)
-				case reflect.String:
-					flagSet.String(fieldName, fieldValue.String(), fieldTag)
-				case reflect.Uint:
-					flagSet.Uint(fieldName, uint(fieldValue.Uint()), fieldTag)
-				case reflect.Uint64:
-					flagSet.Uint64(fieldName, fieldValue.Uint(), fieldTag)
-				}
+		}
+	}
+}
+
+// loadReader decodes the config read from io.Reader (see WithReader) and the files it includes.
+func (l *configLoader) loadReader(src *readerSource) {
+	l.infof("Loading config from '%v'", src.name)
+	data, err := src.read()
+	l.decodeFragment(nil, src.name, "", data, err)
+}
+
+// loadFragment decodes single config file of 'fsys' (OS file system if 'fsys' is 'nil')
+// and the files it includes. 'origin' describes how the file is referenced, it is shown in messages.
+func (l *configLoader) loadFragment(fsys fs.FS, path, origin string) {
+	for i, p := range l.including {
+		if p == path {
+			chain := strings.Join(append(l.including[i:], path), "' -> '")
+			l.addError(fmt.Errorf("include cycle: '%v'", chain), &ConfigErrorEntry{Kind: ErrConfigIncludeCycle,
+				Source: SourceFile, File: path, Origin: origin})
+			return
+		}
+	}
+	if origin != "" {
+		l.infof("Loading config from '%v' (%v)", path, origin)
+	} else {
+		l.infof("Loading config from '%v'", path)
+	}
+	data, err := readConfigFile(fsys, path)
+	if fsys == nil {
+		l.files = append(l.files, path) // before the files it includes
+	}
+	decoded := l.decodeFragment(fsys, path, origin, data, err)
+	if fsys == nil && !decoded {
+		l.files = l.files[:len(l.files)-1] // the file is broken, it includes nothing
+	}
+	migrated := l.migrated[path]
+	delete(l.migrated, path)
+	if fsys == nil && decoded && migrated != nil && l.rewriteMigrated {
+		l.rewriteMigratedFile(path, migrated)
+	}
+}
+
+// decodeFragment decodes the content 'data' of config file 'path' and loads the files it includes.
+// 'readErr' is the error of reading the file. It returns 'false' if the file is not decoded.
+func (l *configLoader) decodeFragment(fsys fs.FS, path, origin string, data []byte, readErr error) bool {
+	decoder, err := l.getDecoder(path)
+	if err == nil && l.migrating {
+		decoder = l.getMigratingDecoder(path, decoder)
+	}
+	if err == nil && l.templates != nil {
+		decoder = l.getTemplateDecoder(decoder)
+	}
+	if err == nil && l.lenient && getConfigFormatName(path, l.format) != "env" { // dotenv keys are mapped like env vars
+		decoder = getLenientDecoder(decoder)
+	}
+	if err == nil && l.entryDefaults {
+		decoder = getEntryDefaultsDecoder(decoder)
+	}
+	if err == nil {
+		if err = readErr; err == nil {
+			if l.fileData == nil {
+				l.fileData = map[string][]byte{}
 			}
-			if err := flagSet.Parse(cmdLine); err != nil {
-				msg := fmt.Sprintf("Error parsing command line:\n%v\n", err)
-				errMsg += msg
-				if verbose {
-					fmt.Fprint(os.Stderr, msg)
-				}
+			l.fileData[path] = data
+			before := l.snapshot()
+			if err = decoder(data, l.config); err == nil {
+				l.trackFile(path, data, decoder, before)
+				l.applyProfile(path, origin, data, decoder)
 			}
-			flagSet.Visit(func(f *flag.Flag) {
-				keyVal := fmt.Sprintf("%v = %v\n", f.Name, f.Value)
-				tomlStr += keyVal
-			})
-		*/
-		for _, arg := range cmdLine {
-			tomlStr += strings.TrimLeft(arg, "-") + "\n"
-		}
-		tomlStr = strings.TrimRight(tomlStr, "\n")
-		if verbose {
-			fmt.Println("Command line parameters:")
-			fmt.Println(tomlStr)
-		}
-		if _, err := toml.Decode(tomlStr, config); err != nil {
-			msg := fmt.Sprintf("Error parsing command line:\n%v\n", err)
-			errMsg += msg
-			if verbose {
-				fmt.Fprint(os.Stderr, msg)
+		}
+	}
+	if err != nil {
+		entry := newFileErrorEntry(path, data, getConfigFormatName(path, l.format), err)
+		entry.Origin = origin
+		l.addError(err, entry)
+		return false
+	}
+	includes, err := getConfigIncludes(fsys, path, data, decoder)
+	if ce, ok := err.(*ConfigError); ok {
+		pos := findKeyPositions(data, getConfigFormatName(path, l.format))[ConfigIncludeKey]
+		for _, e := range ce.Entries {
+			e.Origin, e.Line, e.Column = origin, pos[0], pos[1]
+			e.lineText = getTextLine(data, e.Line)
+		}
+		l.addError(err, nil)
+	}
+	if l.unknownKeys != UnknownKeysIgnore {
+		l.checkUnknownKeys(path, origin, data, decoder)
+	}
+	l.including = append(l.including, path)
+	for _, include := range includes {
+		l.loadFragment(fsys, include, fmt.Sprintf("included from '%v'", path))
+	}
+	l.including = l.including[:len(l.including)-1]
+	return true
+}
+
+// resolveValues resolves the references in string fields (see ResolveConfigValue).
+// The resolved values are secrets, so they are never printed.
+func (l *configLoader) resolveValues() {
+	resolved, err := resolveStructValues(reflect.ValueOf(l.config).Elem(), os.LookupEnv)
+	for _, path := range resolved {
+		l.infof("Resolved value of '%v' = %v", path, redactedValue)
+		l.redacted = append(l.redacted, path)
+	}
+	if err != nil {
+		l.addError(err, &ConfigErrorEntry{Kind: ErrConfigValue})
+	}
+}
+
+// loadEnv sets the config structure fields from environment variables with 'prefix'.
+func (l *configLoader) loadEnv(prefix string) {
+	vars, err := parseEnvToStruct(os.LookupEnv, prefix, l.config)
+	if len(vars) > 0 {
+		l.infof("Environment variables:")
+		l.logAppliedValues(vars)
+	}
+	l.trackAppliedValues(SourceEnv, vars)
+	if err != nil {
+		l.addError(err, &ConfigErrorEntry{Kind: ErrConfigValue, Source: SourceEnv})
+	}
+	if l.unknownKeys != UnknownKeysIgnore {
+		for _, k := range findUnknownEnvVars(reflect.TypeOf(l.config).Elem(), prefix, os.Environ()) {
+			if k.key == prefix+"_PROFILE" {
+				continue // selects the profile, see WithProfile
 			}
+			l.reportUnknownKey(&ConfigErrorEntry{Kind: ErrConfigUnknownKey, Source: SourceEnv, Name: k.key,
+				Err: errors.New(k.message("environment variable"))})
 		}
 	}
+}
 
-	if errMsg == "" {
-		return nil
+// checkUnknownKeys reports the keys of config file which do not match any config field.
+func (l *configLoader) checkUnknownKeys(path, origin string, data []byte, decoder ConfigDecoder) {
+	format := getConfigFormatName(path, l.format)
+	var positions map[string][2]int
+	structType := reflect.TypeOf(l.config).Elem()
+	keysFormat := format
+	if (l.migrating || l.lenient) && format == "toml" {
+		keysFormat = "" // the keys of decoded map are checked by ParseMapToStruct rules
+	}
+	var unknown []unknownKey
+	for _, k := range findUnknownKeys(structType, data, keysFormat, decoder) {
+		root := strings.SplitN(k.key, ".", 2)[0]
+		isProfile := ConfigProfileKey != "" && strings.EqualFold(root, ConfigProfileKey)
+		isVersion := len(l.migrations) > 0 && strings.EqualFold(root, ConfigVersionKey)
+		if !isProfile && !isVersion && !strings.EqualFold(root, ConfigIncludeKey) {
+			unknown = append(unknown, k)
+		}
+	}
+	var m map[string]interface{}
+	if decoder(data, &m) == nil {
+		for name, table := range getProfileTables(m) {
+			delete(table, configProfileInheritsKey)
+			unknown = append(unknown, findUnknownMapKeys(structType, table, []string{ConfigProfileKey, name})...)
+		}
+	}
+	for _, k := range unknown {
+		if positions == nil {
+			positions = findKeyPositions(data, format)
+		}
+		pos := positions[k.key]
+		l.reportUnknownKey(&ConfigErrorEntry{Kind: ErrConfigUnknownKey, Source: SourceFile, File: path,
+			Line: pos[0], Column: pos[1], Origin: origin, Name: k.key, Err: errors.New(k.message("key")),
+			lineText: getTextLine(data, pos[0])})
+	}
+}
+
+// reportUnknownKey adds the error of unknown key or reports it to the logger according to UnknownKeysPolicy.
+func (l *configLoader) reportUnknownKey(entry *ConfigErrorEntry) {
+	if l.unknownKeys == UnknownKeysFail {
+		l.addError(entry.Err, entry)
+	} else {
+		l.warnf("%v", entry)
+	}
+}
+
+// appliedValue describes the value set to the config field by environment variable or command line flag.
+type appliedValue struct {
+	path  string // path of the structure field: "DB.Host"
+	name  string // name of environment variable or command line flag
+	value string
+}
+
+func (l *configLoader) logAppliedValues(values []appliedValue) {
+	for _, v := range values {
+		l.infof("%v = %v", v.name, v.value)
 	}
-	return fmt.Errorf(strings.TrimRight(errMsg, "\n"))
 }
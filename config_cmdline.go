@@ -0,0 +1,623 @@
+package yagolib
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// ErrHelp is returned by LoadConfig and ParseCmdLineToStruct
+// when '-h' or '--help' flag is found in the command line.
+// LoadConfig prints the usage text (of the selected command, see WithOutput) before returning it.
+// It is returned too after printing the shell completion script (see WriteCompletionScript)
+// and after writing the default config (see ConfigWriteDefaultFlag).
+var ErrHelp = errors.New("help requested")
+
+// cmdLineFlag describes a command line flag made of the config structure field.
+type cmdLineFlag struct {
+	long       string // long name without dashes: "db.max-conn"
+	short      string // short name without dash: "p"
+	help       string
+	path       string // path of the structure field: "DB.MaxConn"
+	value      reflect.Value
+	tag        reflect.StructTag
+	positional bool // the field is tagged `positional`, 'long' is the name shown in usage text
+}
+
+// name returns the name of flag shown in messages: "--db.max-conn" or "<files>" for positional arguments.
+func (f *cmdLineFlag) name() string {
+	if f.positional {
+		return "<" + f.long + ">"
+	}
+	return "--" + f.long
+}
+
+func (f *cmdLineFlag) isBool() bool {
+	return f.value.Kind() == reflect.Bool
+}
+
+// typeName returns the name of the flag value type shown in usage text.
+func (f *cmdLineFlag) typeName() string {
+	return getCmdLineTypeName(f.value.Type())
+}
+
+// getCmdLineTypeName returns the name of type shown in usage text: "int", "duration", "string..."
+// for slices and "key=value..." for maps.
+func getCmdLineTypeName(t reflect.Type) string {
+	switch t {
+	case reflect.TypeOf(time.Time{}):
+		return "time"
+	case reflect.TypeOf(time.Duration(0)):
+		return "duration"
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "int"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "uint"
+	case reflect.Float32, reflect.Float64:
+		return "float"
+	case reflect.Slice:
+		return getCmdLineTypeName(t.Elem()) + "..."
+	case reflect.Map:
+		return getCmdLineTypeName(t.Key()) + "=" + getCmdLineTypeName(t.Elem()) + "..."
+	case reflect.Ptr:
+		return getCmdLineTypeName(t.Elem())
+	}
+	return t.Kind().String()
+}
+
+// getCmdLineFlags makes the list of flags from the fields of structure.
+// The long name of flag is the field name in kebab case or the value of `long:"name"` tag,
+// nested structure fields are prefixed with the name of structure: "db.host".
+// Fields tagged `long:"-"` are not available from the command line.
+func getCmdLineFlags(structValue reflect.Value, prefix, pathPrefix string) []*cmdLineFlag {
+	var flags []*cmdLineFlag
+	structType := structValue.Type()
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		fieldValue := structValue.Field(i)
+		long := field.Tag.Get("long")
+		_, isCommand := field.Tag.Lookup("command")
+		_, isPositional := field.Tag.Lookup("positional")
+		if isCommand || isPositional || long == "-" || !fieldValue.CanSet() {
+			continue
+		}
+		if long == "" {
+			long = strings.ToLower(strings.Replace(ToUpperSnakeCase(field.Name), "_", "-", -1))
+		}
+		if prefix != "" {
+			long = prefix + "." + long
+		}
+		path := pathPrefix + field.Name
+		if isNestedStruct(fieldValue) {
+			flags = append(flags, getCmdLineFlags(fieldValue, long, path+".")...)
+			continue
+		}
+		flags = append(flags, &cmdLineFlag{
+			long:  long,
+			short: field.Tag.Get("short"),
+			help:  field.Tag.Get("help"),
+			path:  path,
+			value: fieldValue,
+			tag:   field.Tag,
+		})
+	}
+	return flags
+}
+
+// getCmdLinePositionals returns the fields of structure tagged `positional` which receive
+// the positional arguments. A slice field receives all remaining arguments.
+func getCmdLinePositionals(structValue reflect.Value, pathPrefix string) []*cmdLineFlag {
+	var positionals []*cmdLineFlag
+	structType := structValue.Type()
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		if _, ok := field.Tag.Lookup("positional"); !ok || !structValue.Field(i).CanSet() {
+			continue
+		}
+		positionals = append(positionals, &cmdLineFlag{
+			long:       strings.ToLower(strings.Replace(ToUpperSnakeCase(field.Name), "_", "-", -1)),
+			help:       field.Tag.Get("help"),
+			path:       pathPrefix + field.Name,
+			value:      structValue.Field(i),
+			tag:        field.Tag,
+			positional: true,
+		})
+	}
+	return positionals
+}
+
+// findCmdLineFlag returns the flag with long 'name'. The case of symbols and '-', '_', '.'
+// chars are ignored, so "--db-max-conn", "--DB.MaxConn" and "--db_max_conn" are the same flag.
+func findCmdLineFlag(flags []*cmdLineFlag, name string) *cmdLineFlag {
+	normName := RemoveCharacters(name, "-_.")
+	for _, f := range flags {
+		if strings.EqualFold(normName, RemoveCharacters(f.long, "-_.")) {
+			return f
+		}
+	}
+	return nil
+}
+
+func findShortCmdLineFlag(flags []*cmdLineFlag, name string) *cmdLineFlag {
+	for _, f := range flags {
+		if f.short == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// findHiddenCmdLineFlag returns the value of hidden flag '--name <value>' (or '--name=<value>') handled
+// by LoadConfig itself, like '--completion bash'. The flag is ignored if the config structure
+// has the field with the same flag name.
+func findHiddenCmdLineFlag(cmdLine []string, name string, structValue reflect.Value) (string, bool) {
+	if name == "" || findCmdLineFlag(getCmdLineFlags(structValue, "", ""), name) != nil {
+		return "", false
+	}
+	flag := "--" + name
+	for i, arg := range cmdLine {
+		switch {
+		case arg == "--":
+			return "", false
+		case arg == flag && i+1 < len(cmdLine):
+			return cmdLine[i+1], true
+		case strings.HasPrefix(arg, flag+"="):
+			return arg[len(flag)+1:], true
+		}
+	}
+	return "", false
+}
+
+// hasHiddenCmdLineSwitch reports whether hidden boolean flag '--name' (or '--name=<bool>') handled
+// by LoadConfig itself is set, like '--force'. The flag is ignored if the config structure
+// has the field with the same flag name.
+func hasHiddenCmdLineSwitch(cmdLine []string, name string, structValue reflect.Value) bool {
+	if name == "" || findCmdLineFlag(getCmdLineFlags(structValue, "", ""), name) != nil {
+		return false
+	}
+	flag := "--" + name
+	for _, arg := range cmdLine {
+		switch {
+		case arg == "--":
+			return false
+		case arg == flag:
+			return true
+		case strings.HasPrefix(arg, flag+"="):
+			var set bool
+			return TryToConvert(arg[len(flag)+1:], &set, nil) == nil && set
+		}
+	}
+	return false
+}
+
+// cmdLineCommand describes a subcommand made of the config structure field tagged `command:"name"`.
+type cmdLineCommand struct {
+	name  string
+	help  string
+	path  string        // path of the structure field: "Remote.Add"
+	value reflect.Value // pointer to the structure of command options
+}
+
+// getCmdLineCommands returns the subcommands defined by the fields of structure.
+// The command field must be a pointer to structure, the name of command is the value of
+// `command` tag or the field name in kebab case.
+func getCmdLineCommands(structValue reflect.Value, pathPrefix string) []*cmdLineCommand {
+	var commands []*cmdLineCommand
+	structType := structValue.Type()
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		fieldValue := structValue.Field(i)
+		name, ok := field.Tag.Lookup("command")
+		if !ok || !fieldValue.CanSet() || fieldValue.Kind() != reflect.Ptr ||
+			!isNestedStruct(reflect.Zero(field.Type.Elem())) {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(strings.Replace(ToUpperSnakeCase(field.Name), "_", "-", -1))
+		}
+		commands = append(commands, &cmdLineCommand{
+			name:  name,
+			help:  field.Tag.Get("help"),
+			path:  pathPrefix + field.Name,
+			value: fieldValue,
+		})
+	}
+	return commands
+}
+
+func findCmdLineCommand(commands []*cmdLineCommand, name string) *cmdLineCommand {
+	for _, c := range commands {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// getSelectedCmdLineCommands returns the chain of selected commands: the commands which fields are not 'nil'.
+func getSelectedCmdLineCommands(structValue reflect.Value) []*cmdLineCommand {
+	var selected []*cmdLineCommand
+	pathPrefix := ""
+	for {
+		var next *cmdLineCommand
+		for _, c := range getCmdLineCommands(structValue, pathPrefix) {
+			if !c.value.IsNil() {
+				next = c
+				break
+			}
+		}
+		if next == nil {
+			return selected
+		}
+		selected = append(selected, next)
+		structValue, pathPrefix = next.value.Elem(), next.path+"."
+	}
+}
+
+// resetCmdLineCommands sets the fields of commands which are not 'selected' (by path) to 'nil'.
+func resetCmdLineCommands(structValue reflect.Value, pathPrefix string, selected map[string]bool) {
+	for _, c := range getCmdLineCommands(structValue, pathPrefix) {
+		if selected[c.path] {
+			resetCmdLineCommands(c.value.Elem(), c.path+".", selected)
+		} else {
+			c.value.Set(reflect.Zero(c.value.Type()))
+		}
+	}
+}
+
+// GetCmdLineCommand returns the command selected by the command line parsed into the structure
+// pointed to by 'structPtr' (see ParseCmdLineToStruct): "sync" or "remote add" for nested commands.
+// It returns empty string if no command is selected.
+func GetCmdLineCommand(structPtr interface{}) string {
+	if structPtr == nil || reflect.TypeOf(structPtr).Kind() != reflect.Ptr ||
+		reflect.TypeOf(structPtr).Elem().Kind() != reflect.Struct {
+		return ""
+	}
+	var names []string
+	for _, c := range getSelectedCmdLineCommands(reflect.ValueOf(structPtr).Elem()) {
+		names = append(names, c.name)
+	}
+	return strings.Join(names, " ")
+}
+
+// ParseCmdLineToStruct sets the fields of structure pointed to by 'dstPtr' from command line
+// arguments 'cmdLine' (without the name of executable).
+// The following forms are supported:
+//
+//	--name value, --name=value, -n value, -n=value, -nvalue
+//	--flag, --no-flag, -abc (for boolean fields 'a', 'b' and 'c')
+//	name=value (for compatibility with previous versions of LoadConfig)
+//	-- (the end of flags)
+//
+// The long and short names of flags are defined by field tags:
+//
+//	type Config struct {
+//		Port int `long:"port" short:"p" help:"TCP port to listen"`
+//	}
+//
+// The values are converted by TryToConvert. Surrounding quotes of the value are removed.
+// The values of slice and map flags are comma-separated lists: '--tag=a,b', '--label k1=v1,k2=v2'.
+// The flag may be repeated to add the items: '--tag a --tag b', '--label k1=v1 --label k2=v2'.
+// The first occurrence of flag replaces the value set by config files and environment variables.
+//
+// The positional arguments (and all arguments after '--') are set to the fields tagged `positional`
+// in the order of fields. A slice field receives all remaining arguments, the arguments are not split by commas:
+//
+//	type Config struct {
+//		Output string   `positional:"" help:"Output file"`
+//		Inputs []string `positional:"" help:"Input files"`
+//	}
+//
+// The fields tagged `command:"name"` are subcommands, they must be pointers to structures:
+//
+//	type Config struct {
+//		Verbose bool      `short:"v"`
+//		Sync    *struct {
+//			DryRun bool
+//		} `command:"sync" help:"Synchronize files"`
+//	}
+//
+// The global flags are given before the command and the flags of command after it: 'tool -v sync --dry-run'.
+// The global flags are accepted after the command too, unless the command has a flag with the same name.
+// Commands may be nested. The command structure is allocated (and its defaults are set) when the command
+// is selected, the fields of the commands not selected are set to 'nil'. See GetCmdLineCommand.
+//
+// If '-h' or '--help' flag is found the function returns ErrHelp, see GetCmdLineUsage.
+// The function returns the number of fields set and error (ConfigError).
+func ParseCmdLineToStruct(cmdLine []string, dstPtr interface{}) (int, error) {
+	set, err := parseCmdLineToStruct(cmdLine, dstPtr)
+	return len(set), err
+}
+
+// parseCmdLineToStruct returns the list of flags applied.
+func parseCmdLineToStruct(cmdLine []string, dstPtr interface{}) ([]appliedValue, error) {
+	if dstPtr == nil || reflect.TypeOf(dstPtr).Kind() != reflect.Ptr ||
+		reflect.TypeOf(dstPtr).Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("'dstPtr' must be pointer to structure")
+	}
+	root := reflect.ValueOf(dstPtr).Elem()
+	flags := getCmdLineFlags(root, "", "")
+	positionals := getCmdLinePositionals(root, "")
+	commands := getCmdLineCommands(root, "")
+	selected := map[string]bool{}
+	defer resetCmdLineCommands(root, "", selected)
+	var set []appliedValue
+	var errs ConfigError
+	addError := func(name string, err error) {
+		errs.Entries = append(errs.Entries, &ConfigErrorEntry{Kind: ErrConfigArgument, Source: SourceCmdLine,
+			Name: name, Err: err})
+	}
+	seen := map[*cmdLineFlag]bool{} // the flags found in the command line
+	setValue := func(f *cmdLineFlag, value string) {
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		var err error
+		switch kind := f.value.Kind(); {
+		case f.positional && kind == reflect.Slice:
+			elem := reflect.New(f.value.Type().Elem())
+			if err = TryToConvert(value, elem.Interface(), nil); err == nil {
+				if !seen[f] {
+					f.value.Set(reflect.MakeSlice(f.value.Type(), 0, 1))
+				}
+				f.value.Set(reflect.Append(f.value, elem.Elem()))
+			}
+		case seen[f] && kind == reflect.Slice:
+			items := reflect.New(f.value.Type())
+			if err = TryToConvert(value, items.Interface(), nil); err == nil {
+				f.value.Set(reflect.AppendSlice(f.value, items.Elem()))
+			}
+		case seen[f] && kind == reflect.Map:
+			items := reflect.New(f.value.Type())
+			if err = TryToConvert(value, items.Interface(), nil); err == nil {
+				if f.value.IsNil() {
+					f.value.Set(reflect.MakeMap(f.value.Type()))
+				}
+				for iter := items.Elem().MapRange(); iter.Next(); {
+					f.value.SetMapIndex(iter.Key(), iter.Value())
+				}
+			}
+		default:
+			err = TryToConvert(value, f.value.Addr().Interface(), nil)
+		}
+		seen[f] = true
+		if err != nil {
+			errs.Entries = append(errs.Entries, &ConfigErrorEntry{Kind: ErrConfigValue, Source: SourceCmdLine,
+				Field: f.path, Name: f.name(), Value: value, Err: err})
+			return
+		}
+		set = append(set, appliedValue{path: f.path, name: f.name(), value: value})
+	}
+	// setPositional sets the next positional field, it returns 'false' if there are no more positional fields
+	setPositional := func(value string) bool {
+		if len(positionals) == 0 {
+			return false
+		}
+		f := positionals[0]
+		setValue(f, value)
+		if f.value.Kind() != reflect.Slice {
+			positionals = positionals[1:]
+		}
+		return true
+	}
+
+	for i := 0; i < len(cmdLine); i++ {
+		arg := cmdLine[i]
+		switch {
+		case arg == "--":
+			for _, a := range cmdLine[i+1:] {
+				if !setPositional(a) {
+					addError(a, errors.New("unexpected argument"))
+				}
+			}
+			i = len(cmdLine)
+		case arg == "-h" || arg == "--help":
+			return set, ErrHelp
+		case strings.HasPrefix(arg, "--"):
+			name, value := arg[2:], ""
+			hasValue := false
+			if j := strings.Index(name, "="); j >= 0 {
+				name, value, hasValue = name[:j], name[j+1:], true
+			}
+			f := findCmdLineFlag(flags, name)
+			if f == nil && !hasValue && strings.HasPrefix(name, "no-") {
+				if f = findCmdLineFlag(flags, name[3:]); f != nil && f.isBool() {
+					setValue(f, "false")
+					continue
+				}
+				f = nil
+			}
+			if f == nil {
+				longs := make([]string, len(flags))
+				for j, flag := range flags {
+					longs[j] = "--" + flag.long
+				}
+				k := unknownKey{key: "--" + name, suggestion: suggestName(name, longs)}
+				addError(arg, errors.New(k.message("flag")))
+				continue
+			}
+			if !hasValue {
+				if f.isBool() {
+					value = "true"
+				} else if i+1 < len(cmdLine) {
+					i++
+					value = cmdLine[i]
+				} else {
+					addError("--"+f.long, errors.New("flag needs a value"))
+					continue
+				}
+			}
+			setValue(f, value)
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+			shorts := []rune(arg[1:])
+			for j := 0; j < len(shorts); j++ {
+				f := findShortCmdLineFlag(flags, string(shorts[j]))
+				if f == nil {
+					addError("-"+string(shorts[j]), errors.New("unknown flag"))
+					break
+				}
+				if f.isBool() && (j+1 == len(shorts) || shorts[j+1] != '=') {
+					setValue(f, "true")
+					continue
+				}
+				value := strings.TrimPrefix(string(shorts[j+1:]), "=")
+				if j+1 == len(shorts) {
+					if i+1 < len(cmdLine) {
+						i++
+						value = cmdLine[i]
+					} else {
+						addError("-"+f.short, errors.New("flag needs a value"))
+						break
+					}
+				}
+				setValue(f, value)
+				break
+			}
+		default:
+			if c := findCmdLineCommand(commands, arg); c != nil {
+				if c.value.IsNil() {
+					c.value.Set(reflect.New(c.value.Type().Elem()))
+					setDefaults(c.value.Elem(), c.path+".", map[reflect.Type]bool{}, &errs)
+				}
+				selected[c.path] = true
+				flags = append(getCmdLineFlags(c.value.Elem(), "", c.path+"."), flags...)
+				positionals = getCmdLinePositionals(c.value.Elem(), c.path+".")
+				commands = getCmdLineCommands(c.value.Elem(), c.path+".")
+				continue
+			}
+			if j := strings.Index(arg, "="); j > 0 {
+				if f := findCmdLineFlag(flags, arg[:j]); f != nil {
+					setValue(f, arg[j+1:])
+					continue
+				}
+			}
+			if setPositional(arg) {
+				continue
+			}
+			if len(commands) > 0 {
+				names := make([]string, len(commands))
+				for j, c := range commands {
+					names[j] = c.name
+				}
+				k := unknownKey{key: arg, suggestion: suggestName(arg, names)}
+				addError(arg, errors.New(k.message("command")))
+				continue
+			}
+			addError(arg, errors.New("unexpected argument"))
+		}
+	}
+	return set, errs.err()
+}
+
+// GetCmdLineUsage returns the usage text of application 'appName' for the command line
+// parsed by ParseCmdLineToStruct into the structure pointed to by 'structPtr'.
+// The current values of the structure fields are shown as defaults,
+// the descriptions of flags are taken from `help:"..."` tags.
+// If a command is selected (see GetCmdLineCommand) the usage of the command is returned:
+// its description, subcommands and flags, then the flags of enclosing commands as global options.
+func GetCmdLineUsage(appName string, structPtr interface{}) string {
+	var sb strings.Builder
+	if structPtr == nil || reflect.TypeOf(structPtr).Kind() != reflect.Ptr ||
+		reflect.TypeOf(structPtr).Elem().Kind() != reflect.Struct {
+		fmt.Fprintf(&sb, "Usage: %v [options]\n\nOptions:\n", appName)
+		return sb.String()
+	}
+	structValue := reflect.ValueOf(structPtr).Elem()
+	flags := getCmdLineFlags(structValue, "", "")
+	var globalFlags []*cmdLineFlag
+	usage, help := appName+" [options]", ""
+	for _, c := range getSelectedCmdLineCommands(structValue) {
+		globalFlags = append(flags, globalFlags...)
+		structValue = c.value.Elem()
+		flags = getCmdLineFlags(structValue, "", c.path+".")
+		usage, help = strings.TrimSuffix(usage, " [options]")+" "+c.name+" [options]", c.help
+	}
+	commandUsage := strings.TrimSuffix(usage, " [options]") // to show how to get help of subcommands
+	commands := getCmdLineCommands(structValue, "")
+	if len(commands) > 0 {
+		usage += " <command> [command options]"
+	}
+	positionals := getCmdLinePositionals(structValue, "")
+	for _, p := range positionals {
+		if p.value.Kind() == reflect.Slice {
+			usage += " [" + p.name() + "...]"
+		} else {
+			usage += " " + p.name()
+		}
+	}
+	fmt.Fprintf(&sb, "Usage: %v\n", usage)
+	if help != "" {
+		fmt.Fprintf(&sb, "\n%v\n", help)
+	}
+
+	if len(positionals) > 0 {
+		width := 0
+		for _, p := range positionals {
+			if len(p.name()) > width {
+				width = len(p.name())
+			}
+		}
+		sb.WriteString("\nArguments:\n")
+		for _, p := range positionals {
+			fmt.Fprintf(&sb, "  %-*v  %v\n", width, p.name(), p.help)
+		}
+	}
+
+	if len(commands) > 0 {
+		width := 0
+		for _, c := range commands {
+			if len(c.name) > width {
+				width = len(c.name)
+			}
+		}
+		sb.WriteString("\nCommands:\n")
+		for _, c := range commands {
+			fmt.Fprintf(&sb, "  %-*v  %v\n", width, c.name, c.help)
+		}
+	}
+
+	names := map[*cmdLineFlag]string{}
+	width := len("-h, --help")
+	for _, f := range append(append([]*cmdLineFlag{}, flags...), globalFlags...) {
+		name := "    --" + f.long
+		if f.short != "" {
+			name = "-" + f.short + ", --" + f.long
+		}
+		if f.isBool() {
+			name += ", --no-" + f.long
+		} else {
+			name += " <" + f.typeName() + ">"
+		}
+		if len(name) > width {
+			width = len(name)
+		}
+		names[f] = name
+	}
+	writeFlags := func(flags []*cmdLineFlag) {
+		for _, f := range flags {
+			fmt.Fprintf(&sb, "  %-*v  %v", width, names[f], f.help)
+			if !f.value.IsZero() {
+				if f.help != "" {
+					sb.WriteString(" ")
+				}
+				fmt.Fprintf(&sb, "(default: %v)", f.value.Interface())
+			}
+			sb.WriteString("\n")
+		}
+	}
+	sb.WriteString("\nOptions:\n")
+	writeFlags(flags)
+	fmt.Fprintf(&sb, "  %-*v  Show this help\n", width, "-h, --help")
+	if len(globalFlags) > 0 {
+		sb.WriteString("\nGlobal options:\n")
+		writeFlags(globalFlags)
+	}
+	if len(commands) > 0 {
+		fmt.Fprintf(&sb, "\nRun '%v <command> --help' for the options of command.\n", commandUsage)
+	}
+	return sb.String()
+}
@@ -0,0 +1,348 @@
+package yagolib
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// ConfigWriteDefaultFlag is the name of hidden command line flag which makes LoadConfig write
+// the annotated default config (see WriteDefaultConfig) to the given file:
+//
+//	myapp --write-default-config ~/.config/myapp/config.toml
+//
+// Existing file is not overwritten unless '--force' flag is given too.
+// Set the variable to "" to disable the flag.
+var ConfigWriteDefaultFlag = "write-default-config"
+
+// configForceFlag is the name of hidden command line flag allowing ConfigWriteDefaultFlag
+// to overwrite existing file.
+const configForceFlag = "force"
+
+// configDocEntry describes the config key for generated config files and documentation.
+type configDocEntry struct {
+	key      string   // TOML key path: "db.host"
+	keyPath  []string // the parts of key path: "db", "host"
+	name     string   // the last part of key: "host"
+	field    reflect.StructField
+	value    reflect.Value
+	env      string            // name of environment variable, empty if the field is not read from environment
+	flag     string            // command line flag: "--db.host", empty if the field is not read from command line
+	entries  []*configDocEntry // the keys of table for nested structure
+	isStruct bool
+}
+
+// getConfigDocEntries returns the keys of config structure pointed to by 'config' with the default values:
+// the values of the structure with `default` tags applied.
+// 'envPrefix' is the prefix of environment variables, empty if environment variables are not read.
+func getConfigDocEntries(config interface{}, envPrefix string) ([]*configDocEntry, error) {
+	if config == nil || reflect.TypeOf(config).Kind() != reflect.Ptr ||
+		reflect.TypeOf(config).Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("'config' argument is not a pointer to structure. It has type: %T", config)
+	}
+	defaults := reflect.New(reflect.TypeOf(config).Elem())
+	defaults.Elem().Set(reflect.ValueOf(config).Elem())
+	if err := SetDefaults(defaults.Interface()); err != nil {
+		return nil, err
+	}
+	flags := map[string]string{}
+	for _, f := range getCmdLineFlags(defaults.Elem(), "", "") {
+		flags[f.path] = "--" + f.long
+	}
+	var walk func(structValue reflect.Value, keyPrefix []string, pathPrefix, envPrefix string) []*configDocEntry
+	walk = func(structValue reflect.Value, keyPrefix []string, pathPrefix, envPrefix string) []*configDocEntry {
+		var entries []*configDocEntry
+		structType := structValue.Type()
+		for i := 0; i < structType.NumField(); i++ {
+			field := structType.Field(i)
+			_, isCommand := field.Tag.Lookup("command")
+			_, isPositional := field.Tag.Lookup("positional")
+			name := strings.Split(field.Tag.Get("toml"), ",")[0]
+			if field.PkgPath != "" || isCommand || isPositional || isFieldExcluded(field) {
+				continue
+			}
+			if name == "" {
+				name = strings.ToLower(field.Name)
+			}
+			keyPath := append(append([]string{}, keyPrefix...), name)
+			entry := &configDocEntry{key: strings.Join(keyPath, "."), keyPath: keyPath, name: name, field: field,
+				value: structValue.Field(i)}
+			if envPrefix != "" {
+				entry.env = getEnvVarName(field, envPrefix)
+			}
+			path := pathPrefix + field.Name
+			if isNestedStruct(entry.value) {
+				entry.isStruct = true
+				entry.entries = walk(entry.value, keyPath, path+".", entry.env)
+			} else {
+				entry.flag = flags[path]
+			}
+			entries = append(entries, entry)
+		}
+		return entries
+	}
+	return walk(defaults.Elem(), nil, "", envPrefix), nil
+}
+
+// getValidateRule returns the argument of validation rule 'name' of `validate` tag.
+func getValidateRule(tag reflect.StructTag, name string) (string, bool) {
+	rules := tag.Get("validate")
+	for rules != "" {
+		rule := rules
+		if strings.HasPrefix(rule, "pattern=") {
+			rules = "" // pattern is the last rule, it may contain commas
+		} else if i := strings.Index(rules, ","); i >= 0 {
+			rule, rules = rules[:i], rules[i+1:]
+		} else {
+			rules = ""
+		}
+		if rule == name {
+			return "", true
+		}
+		if strings.HasPrefix(rule, name+"=") {
+			return rule[len(name)+1:], true
+		}
+	}
+	return "", false
+}
+
+// getOneOfValues returns the values listed by 'oneof' validation rule.
+func getOneOfValues(tag reflect.StructTag) []string {
+	if arg, ok := getValidateRule(tag, "oneof"); ok {
+		return strings.Split(arg, "|")
+	}
+	return nil
+}
+
+// allowedValues describes the values allowed by validation rules: "one of: dev, prod", "min: 1, max: 65535".
+func (e *configDocEntry) allowedValues() string {
+	if values := getOneOfValues(e.field.Tag); values != nil {
+		return "one of: " + strings.Join(values, ", ")
+	}
+	var limits []string
+	for _, name := range []string{"min", "max"} {
+		if arg, ok := getValidateRule(e.field.Tag, name); ok {
+			limits = append(limits, name+": "+arg)
+		}
+	}
+	if pattern, ok := getValidateRule(e.field.Tag, "pattern"); ok {
+		limits = append(limits, "pattern: "+pattern)
+	}
+	return strings.Join(limits, ", ")
+}
+
+// defaultValue returns the default value in TOML format, empty string if the value can not be written.
+func (e *configDocEntry) defaultValue() string {
+	value, err := FormatTOMLValue(e.value.Interface())
+	if err != nil {
+		return ""
+	}
+	return value
+}
+
+// typeName returns the name of value type: "int", "string...".
+func (e *configDocEntry) typeName() string {
+	return getCmdLineTypeName(e.field.Type)
+}
+
+// WriteDefaultConfig writes the TOML config with the keys of config structure pointed to by 'config'
+// set to their default values: the current values of the structure with `default` tags applied.
+// Every key is annotated by comments: the description from `help` tag, the values allowed
+// by validation rules, the environment variable and the command line flag setting the key.
+// The keys which default values can not be written in TOML are commented out.
+func WriteDefaultConfig(w io.Writer, config interface{}) error {
+	return writeDefaultConfig(w, config, GetConfigEnvPrefix())
+}
+
+func writeDefaultConfig(w io.Writer, config interface{}, envPrefix string) error {
+	entries, err := getConfigDocEntries(config, envPrefix)
+	if err != nil {
+		return err
+	}
+	var sb strings.Builder
+	writeComment := func(text string) {
+		for _, line := range strings.Split(text, "\n") {
+			sb.WriteString(strings.TrimRight("# "+line, " ") + "\n")
+		}
+	}
+	var writeTable func(entries []*configDocEntry)
+	writeTable = func(entries []*configDocEntry) {
+		for _, e := range entries {
+			if e.isStruct {
+				continue
+			}
+			if sb.Len() > 0 {
+				sb.WriteString("\n")
+			}
+			if help := e.field.Tag.Get("help"); help != "" {
+				writeComment(help)
+			}
+			if allowed := e.allowedValues(); allowed != "" {
+				writeComment("Allowed values: " + allowed)
+			}
+			var sources []string
+			if e.env != "" {
+				sources = append(sources, "environment variable "+e.env)
+			}
+			if e.flag != "" {
+				sources = append(sources, "command line flag "+e.flag)
+			}
+			if len(sources) > 0 {
+				writeComment("Set by " + strings.Join(sources, ", "))
+			}
+			if value := e.defaultValue(); value != "" {
+				fmt.Fprintf(&sb, "%v = %v\n", quoteTOMLKey(e.name), value)
+			} else {
+				fmt.Fprintf(&sb, "# %v = <%v>\n", quoteTOMLKey(e.name), e.typeName())
+			}
+		}
+		for _, e := range entries {
+			if !e.isStruct {
+				continue
+			}
+			if sb.Len() > 0 {
+				sb.WriteString("\n")
+			}
+			if help := e.field.Tag.Get("help"); help != "" {
+				writeComment(help)
+			}
+			fmt.Fprintf(&sb, "[%v]\n", formatTOMLKey(e.keyPath))
+			writeTable(e.entries)
+		}
+	}
+	writeTable(entries)
+	_, err = io.WriteString(w, sb.String())
+	return err
+}
+
+// WriteDefaultConfigFile writes the default config (see WriteDefaultConfig) to file 'path'.
+// The directory of file is created if it does not exist. Existing file is overwritten only
+// if 'overwrite' is 'true', otherwise the error matching os.ErrExist is returned.
+func WriteDefaultConfigFile(path string, config interface{}, overwrite bool) error {
+	return writeDefaultConfigFile(path, config, GetConfigEnvPrefix(), overwrite)
+}
+
+func writeDefaultConfigFile(path string, config interface{}, envPrefix string, overwrite bool) error {
+	path, err := NormalizePath(path)
+	if err != nil {
+		return err
+	}
+	var sb strings.Builder
+	if err = writeDefaultConfig(&sb, config, envPrefix); err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if overwrite {
+		return WriteFileAtomic(path, []byte(sb.String()), 0644)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		if os.IsExist(err) {
+			return fmt.Errorf("Config file '%v' is not written: %w", path, os.ErrExist)
+		}
+		return err
+	}
+	if _, err = file.WriteString(sb.String()); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// WriteConfigMarkdown writes the reference of config keys of structure pointed to by 'config'
+// as Markdown table: the key, type, default value, environment variable, command line flag and description.
+func WriteConfigMarkdown(w io.Writer, config interface{}) error {
+	entries, err := getConfigDocEntries(config, GetConfigEnvPrefix())
+	if err != nil {
+		return err
+	}
+	escape := func(s string) string {
+		return strings.Replace(strings.Replace(s, "|", `\|`, -1), "\n", " ", -1)
+	}
+	code := func(s string) string {
+		if s == "" {
+			return ""
+		}
+		return "`" + escape(s) + "`"
+	}
+	var sb strings.Builder
+	sb.WriteString("| Key | Type | Default | Environment | Flag | Description |\n")
+	sb.WriteString("| --- | --- | --- | --- | --- | --- |\n")
+	forEachConfigDocEntry(entries, func(e *configDocEntry) {
+		description := e.field.Tag.Get("help")
+		if allowed := e.allowedValues(); allowed != "" {
+			if description = strings.TrimSuffix(description, "."); description != "" {
+				description += ". "
+			}
+			description += "Allowed values: " + allowed
+		}
+		fmt.Fprintf(&sb, "| %v | %v | %v | %v | %v | %v |\n", code(e.key), escape(e.typeName()),
+			code(e.defaultValue()), code(e.env), code(e.flag), escape(description))
+	})
+	_, err = io.WriteString(w, sb.String())
+	return err
+}
+
+// WriteConfigManPage writes the description of config keys of structure pointed to by 'config'
+// as CONFIGURATION section of man page (roff format).
+func WriteConfigManPage(w io.Writer, config interface{}) error {
+	entries, err := getConfigDocEntries(config, GetConfigEnvPrefix())
+	if err != nil {
+		return err
+	}
+	escape := func(s string) string {
+		s = strings.Replace(s, `\`, `\e`, -1)
+		s = strings.Replace(s, "-", `\-`, -1)
+		lines := strings.Split(s, "\n")
+		for i, line := range lines {
+			if strings.HasPrefix(line, ".") || strings.HasPrefix(line, "'") {
+				lines[i] = `\&` + line
+			}
+		}
+		return strings.Join(lines, "\n")
+	}
+	var sb strings.Builder
+	sb.WriteString(".SH CONFIGURATION\n")
+	forEachConfigDocEntry(entries, func(e *configDocEntry) {
+		fmt.Fprintf(&sb, ".TP\n.B %v\n", escape(e.key))
+		if help := e.field.Tag.Get("help"); help != "" {
+			sb.WriteString(escape(help) + "\n.br\n")
+		}
+		details := "Type: " + e.typeName()
+		if value := e.defaultValue(); value != "" {
+			details += ". Default: " + value
+		}
+		if allowed := e.allowedValues(); allowed != "" {
+			details += ". Allowed values: " + allowed
+		}
+		sb.WriteString(escape(details) + ".\n")
+		var sources []string
+		if e.env != "" {
+			sources = append(sources, "Environment: "+e.env)
+		}
+		if e.flag != "" {
+			sources = append(sources, "Flag: "+e.flag)
+		}
+		if len(sources) > 0 {
+			sb.WriteString(".br\n" + escape(strings.Join(sources, ". ")) + ".\n")
+		}
+	})
+	_, err = io.WriteString(w, sb.String())
+	return err
+}
+
+// forEachConfigDocEntry calls 'fn' for every key (not table) in the order of structure fields.
+func forEachConfigDocEntry(entries []*configDocEntry, fn func(e *configDocEntry)) {
+	for _, e := range entries {
+		if e.isStruct {
+			forEachConfigDocEntry(e.entries, fn)
+		} else {
+			fn(e)
+		}
+	}
+}
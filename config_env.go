@@ -0,0 +1,120 @@
+package yagolib
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// ConfigEnvPrefix is the prefix of environment variables read by LoadConfig.
+// Empty string means the name of application executable converted by ToUpperSnakeCase
+// ("my-app" -> "MY_APP"). Set it to "-" to disable the environment variables layer.
+var ConfigEnvPrefix string
+
+// GetConfigEnvPrefix returns the prefix of environment variables used by LoadConfig.
+// It returns empty string if the environment variables layer is disabled.
+func GetConfigEnvPrefix() string {
+	return getConfigEnvPrefix(ConfigEnvPrefix)
+}
+
+func getConfigEnvPrefix(prefix string) string {
+	switch prefix {
+	case "-":
+		return ""
+	case "":
+		appName := filepath.Base(os.Args[0])
+		return ToUpperSnakeCase(strings.TrimSuffix(appName, filepath.Ext(appName)))
+	}
+	return prefix
+}
+
+// ParseEnvToStruct sets the fields of structure pointed to by 'dstPtr' from environment variables.
+// The name of variable is made of 'prefix' and the field names converted by ToUpperSnakeCase
+// joined with '_'. For example, with prefix "MYAPP" the variable MYAPP_DB_HOST sets the field
+// 'Host' of nested structure field 'DB' (or 'Db').
+// The field may have `env:"NAME"` tag to replace its part of the name, for nested structure
+// this tag sets the prefix of its fields. Fields tagged `env:"-"` are skipped.
+// The values are converted by TryToConvert, so "0x1F" or "yes" are valid values.
+// Slices and maps are set from comma-separated lists like command line flags are:
+// MYAPP_TAGS="a,b", MYAPP_LABELS="env=prod,zone=eu".
+// The function returns the number of fields set and error (ConfigError).
+func ParseEnvToStruct(prefix string, dstPtr interface{}) (int, error) {
+	vars, err := parseEnvToStruct(os.LookupEnv, prefix, dstPtr)
+	return len(vars), err
+}
+
+// parseEnvToStruct sets the fields of structure from variables found by 'lookup'.
+// It returns the list of variables applied.
+func parseEnvToStruct(lookup func(string) (string, bool), prefix string, dstPtr interface{}) ([]appliedValue, error) {
+	if dstPtr == nil || reflect.TypeOf(dstPtr).Kind() != reflect.Ptr ||
+		reflect.TypeOf(dstPtr).Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("'dstPtr' must be pointer to structure")
+	}
+	var vars []appliedValue
+	var errs ConfigError
+	var walk func(structValue reflect.Value, prefix, pathPrefix string)
+	walk = func(structValue reflect.Value, prefix, pathPrefix string) {
+		structType := structValue.Type()
+		for i := 0; i < structType.NumField(); i++ {
+			field := structType.Field(i)
+			fieldValue := structValue.Field(i)
+			name := getEnvVarName(field, prefix)
+			if name == "" || !fieldValue.CanSet() {
+				continue
+			}
+			path := pathPrefix + field.Name
+			if isNestedStruct(fieldValue) {
+				walk(fieldValue, name, path+".")
+				continue
+			}
+			value, ok := lookup(name)
+			if !ok {
+				continue
+			}
+			if err := TryToConvert(value, fieldValue.Addr().Interface(), nil); err != nil {
+				errs.Entries = append(errs.Entries, &ConfigErrorEntry{Kind: ErrConfigValue, Source: SourceEnv,
+					Field: path, Name: name, Value: value, Err: err})
+				continue
+			}
+			vars = append(vars, appliedValue{path: path, name: name, value: value})
+		}
+	}
+	walk(reflect.ValueOf(dstPtr).Elem(), prefix, "")
+	return vars, errs.err()
+}
+
+// getEnvVarName returns the name of environment variable of structure 'field' (or the prefix
+// of variables of nested structure). It returns empty string for the fields tagged `env:"-"`.
+func getEnvVarName(field reflect.StructField, prefix string) string {
+	name := field.Tag.Get("env")
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		name = ToUpperSnakeCase(field.Name)
+	}
+	if prefix != "" {
+		name = prefix + "_" + name
+	}
+	return name
+}
+
+// getEnvVarNames returns the names of all environment variables read into the structure of 'structType'.
+func getEnvVarNames(structType reflect.Type, prefix string) []string {
+	var names []string
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		name := getEnvVarName(field, prefix)
+		if name == "" || field.PkgPath != "" {
+			continue
+		}
+		if isNestedStruct(reflect.Zero(field.Type)) {
+			names = append(names, getEnvVarNames(field.Type, name)...)
+		} else {
+			names = append(names, name)
+		}
+	}
+	return names
+}
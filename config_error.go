@@ -0,0 +1,246 @@
+package yagolib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// The kinds of config errors. Use errors.Is to check the kind of error returned by LoadConfig:
+//
+//	if errors.Is(err, yagolib.ErrConfigSyntax) { ... }
+var (
+	ErrConfigNotFound     = errors.New("config file not found")
+	ErrConfigSyntax       = errors.New("config syntax error")
+	ErrConfigValue        = errors.New("invalid config value")
+	ErrConfigArgument     = errors.New("invalid command line argument")
+	ErrConfigIncludeCycle = errors.New("config include cycle")
+	ErrConfigValidation   = errors.New("config validation failed")
+	ErrConfigUnknownKey   = errors.New("unknown config key") // see UnknownKeysPolicy
+)
+
+// ConfigErrorEntry describes a single failure of loading config.
+type ConfigErrorEntry struct {
+	Kind   error // one of ErrConfigNotFound, ErrConfigSyntax, ...
+	Source ConfigSource
+	File   string // path of config file, if the error is related to the file
+	Line   int    // position in config file, 0 if unknown
+	Column int
+	Origin string      // how the file is referenced: "included from 'config.toml'", "drop-in of 'config.toml'"
+	Field  string      // path of config field: "DB.Port"
+	Name   string      // config file key, environment variable or command line flag
+	Value  interface{} // the offending value, 'nil' if unknown
+	Err    error       // the cause
+
+	lineText string // the text of line 'Line' of config file
+}
+
+// Location returns "path:line:column" of the error in config file,
+// the name of environment variable or command line flag.
+func (e *ConfigErrorEntry) Location() string {
+	switch {
+	case e.File != "" && e.Line > 0 && e.Column > 0:
+		return fmt.Sprintf("%v:%v:%v", e.File, e.Line, e.Column)
+	case e.File != "" && e.Line > 0:
+		return fmt.Sprintf("%v:%v", e.File, e.Line)
+	case e.File != "":
+		return e.File
+	}
+	return e.Name
+}
+
+func (e *ConfigErrorEntry) Error() string {
+	var sb strings.Builder
+	if loc := e.Location(); loc != "" {
+		sb.WriteString(loc)
+		if e.Origin != "" {
+			fmt.Fprintf(&sb, " (%v)", e.Origin)
+		}
+		sb.WriteString(": ")
+	}
+	if e.Field != "" {
+		fmt.Fprintf(&sb, "field '%v': ", e.Field)
+	}
+	var fieldErr *FieldError
+	switch {
+	case errors.As(e.Err, &fieldErr):
+		sb.WriteString(fieldErr.Message)
+	case e.Err != nil:
+		sb.WriteString(e.Err.Error())
+	case e.Kind != nil:
+		sb.WriteString(e.Kind.Error())
+	}
+	return sb.String()
+}
+
+// Is reports whether the entry is of kind 'target'.
+func (e *ConfigErrorEntry) Is(target error) bool {
+	return e.Kind != nil && target == e.Kind
+}
+
+// Unwrap returns the cause of the error.
+func (e *ConfigErrorEntry) Unwrap() error {
+	return e.Err
+}
+
+// ConfigError is returned by LoadConfig and Loader. It lists all failures found.
+// errors.Is and errors.As are applied to every entry:
+//
+//	var fieldErr *yagolib.FieldError
+//	if errors.As(err, &fieldErr) { ... }
+type ConfigError struct {
+	Entries []*ConfigErrorEntry
+}
+
+func (e *ConfigError) Error() string {
+	msgs := make([]string, len(e.Entries))
+	for i, entry := range e.Entries {
+		msgs[i] = entry.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Is reports whether any entry matches 'target'.
+func (e *ConfigError) Is(target error) bool {
+	for _, entry := range e.Entries {
+		if errors.Is(entry, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first entry matching 'target', see errors.As.
+func (e *ConfigError) As(target interface{}) bool {
+	for _, entry := range e.Entries {
+		if errors.As(entry, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Render returns the human-friendly multi-line description of errors.
+// The offending lines of config files are shown with a caret under the error position:
+//
+//	config.toml:3:8: toml: line 3 (last key "port"): expected value but found "x" instead
+//	  3 | port = 80x
+//	    |        ^
+func (e *ConfigError) Render() string {
+	var sb strings.Builder
+	for _, entry := range e.Entries {
+		sb.WriteString(entry.Error())
+		sb.WriteString("\n")
+		if entry.lineText == "" {
+			continue
+		}
+		num := strconv.Itoa(entry.Line)
+		fmt.Fprintf(&sb, "  %v | %v\n", num, strings.Replace(entry.lineText, "\t", " ", -1))
+		if entry.Column > 0 {
+			fmt.Fprintf(&sb, "  %v | %v^\n", strings.Repeat(" ", len(num)), strings.Repeat(" ", entry.Column-1))
+		}
+	}
+	return sb.String()
+}
+
+// add appends the entries of 'err' if it is ConfigError, otherwise 'err' is added as 'entry' cause.
+func (e *ConfigError) add(err error, entry *ConfigErrorEntry) {
+	if ce, ok := err.(*ConfigError); ok {
+		e.Entries = append(e.Entries, ce.Entries...)
+		return
+	}
+	entry.Err = err
+	e.Entries = append(e.Entries, entry)
+}
+
+// err returns the ConfigError or 'nil' if there are no entries.
+func (e *ConfigError) err() error {
+	if len(e.Entries) == 0 {
+		return nil
+	}
+	return e
+}
+
+var decodeErrorLineRegexp = regexp.MustCompile(`line (\d+)`)
+var decodeErrorKeyRegexp = regexp.MustCompile(`last key "([^"]*)"`)
+
+// newFileErrorEntry makes the error entry of config file 'path' with content 'data' decoded as 'format'.
+// The position of error is taken from the errors of TOML, JSON and YAML decoders
+// or from "line N" text of error message.
+func newFileErrorEntry(path string, data []byte, format string, err error) *ConfigErrorEntry {
+	entry := &ConfigErrorEntry{Kind: ErrConfigSyntax, Source: SourceFile, File: path, Err: err}
+	var (
+		tomlErr      toml.ParseError
+		jsonErr      *json.SyntaxError
+		jsonTypeErr  *json.UnmarshalTypeError
+		yamlTypeErr  *yaml.TypeError
+		msg          = err.Error()
+		offset       = -1
+		tomlPosition = false
+	)
+	switch {
+	case errors.As(err, &tomlErr):
+		entry.Line, entry.Name = tomlErr.Position.Line, tomlErr.LastKey
+		offset, tomlPosition = tomlErr.Position.Start, true
+	case errors.As(err, &jsonErr):
+		offset = int(jsonErr.Offset) - 1
+	case errors.As(err, &jsonTypeErr):
+		offset, entry.Kind, entry.Name = int(jsonTypeErr.Offset)-1, ErrConfigValue, jsonTypeErr.Field
+	case errors.As(err, &yamlTypeErr):
+		entry.Kind = ErrConfigValue
+		if len(yamlTypeErr.Errors) > 0 {
+			msg = yamlTypeErr.Errors[0]
+		}
+	}
+	if strings.Contains(msg, "incompatible types") || strings.Contains(msg, "cannot unmarshal") {
+		entry.Kind = ErrConfigValue
+	}
+	if offset >= 0 && offset <= len(data) {
+		line, column := 1, 1
+		for _, c := range string(data[:offset]) {
+			if c == '\n' {
+				line, column = line+1, 1
+			} else {
+				column++
+			}
+		}
+		if !tomlPosition || line == entry.Line {
+			entry.Line, entry.Column = line, column
+		}
+	}
+	if entry.Line == 0 {
+		if m := decodeErrorLineRegexp.FindStringSubmatch(msg); m != nil {
+			entry.Line, _ = strconv.Atoi(m[1])
+		}
+	}
+	if entry.Name == "" {
+		if m := decodeErrorKeyRegexp.FindStringSubmatch(msg); m != nil {
+			entry.Name = m[1]
+		}
+	}
+	if entry.Name != "" && entry.Column == 0 {
+		if pos, ok := findKeyPositions(data, format)[entry.Name]; ok && (entry.Line == 0 || pos[0] == entry.Line) {
+			entry.Line, entry.Column = pos[0], pos[1]
+		}
+	}
+	entry.lineText = getTextLine(data, entry.Line)
+	return entry
+}
+
+// getTextLine returns the line number 'line' (starting from 1) of 'data'.
+func getTextLine(data []byte, line int) string {
+	if line <= 0 {
+		return ""
+	}
+	lines := strings.Split(string(data), "\n")
+	if line > len(lines) {
+		return ""
+	}
+	return strings.TrimRight(lines[line-1], "\r")
+}
@@ -0,0 +1,218 @@
+package yagolib
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigDecoder decodes configuration data into the value pointed to by 'v'.
+// 'v' is either a pointer to the config structure or a pointer to 'map[string]interface{}'.
+type ConfigDecoder func(data []byte, v interface{}) error
+
+// ConfigFormat overrides detection of the config file format by file extension.
+// It must be the name of registered format ("toml", "json", "yaml", "ini", "env", ...).
+// Empty string means the format is chosen by the extension of each config file.
+var ConfigFormat string
+
+var (
+	configDecodersMu sync.RWMutex
+	configDecoders   = map[string]ConfigDecoder{
+		"toml": decodeTOML,
+		"json": decodeJSON,
+		"yaml": decodeYAML,
+		"yml":  decodeYAML,
+		"ini":  decodeINI,
+		"env":  decodeDotEnv,
+	}
+)
+
+// RegisterConfigDecoder makes config 'decoder' available for files with extension 'format'
+// (case insensitive, leading dot is optional). Registering an existing format replaces its decoder.
+func RegisterConfigDecoder(format string, decoder ConfigDecoder) {
+	format = strings.ToLower(strings.TrimPrefix(format, "."))
+	configDecodersMu.Lock()
+	defer configDecodersMu.Unlock()
+	if decoder == nil {
+		delete(configDecoders, format)
+	} else {
+		configDecoders[format] = decoder
+	}
+}
+
+// GetConfigDecoder returns the decoder for config file 'path'.
+// If 'format' is not empty it is used instead of the file extension.
+// Files with unknown or missing extension are treated as TOML.
+func GetConfigDecoder(path, format string) (ConfigDecoder, error) {
+	configDecodersMu.RLock()
+	defer configDecodersMu.RUnlock()
+	if format != "" {
+		format = strings.ToLower(strings.TrimPrefix(format, "."))
+		if decoder, ok := configDecoders[format]; ok {
+			return decoder, nil
+		}
+		return nil, fmt.Errorf("Config format '%v' is not registered", format)
+	}
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+	if decoder, ok := configDecoders[ext]; ok {
+		return decoder, nil
+	}
+	return configDecoders["toml"], nil
+}
+
+func decodeTOML(data []byte, v interface{}) error {
+	_, err := toml.Decode(string(data), v)
+	return err
+}
+
+func decodeJSON(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func decodeYAML(data []byte, v interface{}) error {
+	return yaml.Unmarshal(data, v)
+}
+
+// decodeINI parses INI file. Keys before the first section are top level keys,
+// '[section]' and '[section.subsection]' headers produce nested tables.
+func decodeINI(data []byte, v interface{}) error {
+	root := map[string]interface{}{}
+	table := root
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return fmt.Errorf("line %v: invalid section header: %v", lineNum, line)
+			}
+			table = root
+			for _, name := range strings.Split(strings.Trim(line, "[]"), ".") {
+				name = strings.TrimSpace(name)
+				sub, ok := table[name].(map[string]interface{})
+				if !ok {
+					sub = map[string]interface{}{}
+					table[name] = sub
+				}
+				table = sub
+			}
+			continue
+		}
+		i := strings.IndexAny(line, "=:")
+		if i <= 0 {
+			return fmt.Errorf("line %v: expected 'key = value': %v", lineNum, line)
+		}
+		table[strings.TrimSpace(line[:i])] = unquoteConfigValue(strings.TrimSpace(line[i+1:]))
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return storeConfigMap(root, v)
+}
+
+// decodeDotEnv parses dotenv file: 'KEY=value' lines, optionally prefixed with 'export'.
+// The keys are mapped to the structure fields like environment variables (see ParseEnvToStruct)
+// without prefix.
+func decodeDotEnv(data []byte, v interface{}) error {
+	root := map[string]interface{}{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+		i := strings.Index(line, "=")
+		if i <= 0 {
+			return fmt.Errorf("line %v: expected 'KEY=value': %v", lineNum, line)
+		}
+		root[strings.TrimSpace(line[:i])] = unquoteConfigValue(strings.TrimSpace(line[i+1:]))
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if _, ok := v.(*map[string]interface{}); ok {
+		return storeConfigMap(root, v)
+	}
+	// dotenv keys follow environment variable naming: DB_HOST sets the field 'Host' of 'DB' structure
+	lookup := func(name string) (string, bool) {
+		value, ok := root[name]
+		return fmt.Sprint(value), ok
+	}
+	_, err := parseEnvToStruct(lookup, "", v)
+	return err
+}
+
+// unquoteConfigValue removes surrounding quotes from INI/dotenv value
+// or strips trailing comment from unquoted value.
+func unquoteConfigValue(value string) string {
+	if len(value) >= 2 {
+		if q := value[0]; (q == '"' || q == '\'') && strings.LastIndexByte(value, q) > 0 {
+			return value[1:strings.LastIndexByte(value, q)]
+		}
+	}
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = value[:i]
+	}
+	if i := strings.Index(value, " ;"); i >= 0 {
+		value = value[:i]
+	}
+	return strings.TrimSpace(value)
+}
+
+// ConfigLenient makes LoadConfig and Loader decode all config files like INI and dotenv files are decoded:
+// into maps which are stored to the config structure by ParseMapToStruct rules. So the keys are matched
+// ignoring the case and '-'/'_' separators ('max_conn', 'maxConn' and 'max-conn' set the field MaxConn),
+// the aliases of fields are taken into account and the values are converted by TryToConvert
+// ("yes" and "on" are booleans, "0x1F" is an integer, comma-separated string is a slice).
+// The same rules are applied to nested tables, arrays of tables and maps of tables.
+// The keys of dotenv files are always mapped like environment variables: DB_HOST sets 'DB.Host'.
+// By default the files are decoded into the config structure by the decoders of their formats
+// (TOML files by github.com/BurntSushi/toml), which are strict about the types of values.
+var ConfigLenient bool
+
+// getLenientDecoder returns the decoder decoding the data into map by 'decoder' and storing the map
+// to the config structure by ParseMapToStruct rules, see ConfigLenient.
+func getLenientDecoder(decoder ConfigDecoder) ConfigDecoder {
+	return func(data []byte, v interface{}) error {
+		if _, isMap := v.(*map[string]interface{}); isMap {
+			return decoder(data, v)
+		}
+		var m map[string]interface{}
+		if err := decoder(data, &m); err != nil {
+			return err
+		}
+		return storeConfigMap(m, v)
+	}
+}
+
+// storeConfigMap stores map parsed by INI/dotenv decoder to 'v'.
+// Structures are filled by ParseMapToStruct rules so values are converted by TryToConvert.
+// The structure is not validated here because other config sources may follow.
+func storeConfigMap(m map[string]interface{}, v interface{}) error {
+	if mapPtr, ok := v.(*map[string]interface{}); ok {
+		if *mapPtr == nil {
+			*mapPtr = m
+		} else {
+			for key, value := range m {
+				(*mapPtr)[key] = value
+			}
+		}
+		return nil
+	}
+	if v == nil || reflect.TypeOf(v).Kind() != reflect.Ptr {
+		return fmt.Errorf("can't decode into %T", v)
+	}
+	_, err := parseMapToStruct(m, v)
+	return err
+}
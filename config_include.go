@@ -0,0 +1,118 @@
+package yagolib
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ConfigIncludeKey is the name of config file key listing the files loaded after the file:
+//
+//	include = ["common.toml", "conf/*.toml"]
+//
+// The value is a file path or a list of them, glob patterns are allowed.
+// Relative paths are resolved against the directory of the including file.
+// The files matching a pattern are loaded in lexical order, the settings of included files
+// override the settings of the including file. Include cycles are reported as errors.
+// The files of the drop-in directory '<config file>.d' are loaded after the config file itself
+// in the same way. The drop-ins of 'configPath' of LoadConfig are loaded even if the file itself is missing.
+// Set the variable to "" to disable includes.
+var ConfigIncludeKey = "include"
+
+// getConfigIncludes returns the paths of files included by config file 'file'
+// which content 'data' is decoded by 'decoder'. The files are searched in 'fsys'
+// or in OS file system if 'fsys' is 'nil'. The error is ConfigError.
+func getConfigIncludes(fsys fs.FS, file string, data []byte, decoder ConfigDecoder) ([]string, error) {
+	if ConfigIncludeKey == "" {
+		return nil, nil
+	}
+	var m map[string]interface{}
+	if decoder(data, &m) != nil {
+		return nil, nil
+	}
+	var value interface{}
+	for k, v := range m {
+		if strings.EqualFold(k, ConfigIncludeKey) {
+			value = v
+			break
+		}
+	}
+	invalid := &ConfigError{[]*ConfigErrorEntry{{Kind: ErrConfigSyntax, Source: SourceFile, File: file,
+		Name: ConfigIncludeKey, Value: value,
+		Err: fmt.Errorf("'%v' must be a string or a list of strings", ConfigIncludeKey)}}}
+	var patterns []string
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		patterns = []string{v}
+	case []interface{}:
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, invalid
+			}
+			patterns = append(patterns, s)
+		}
+	default:
+		return nil, invalid
+	}
+
+	var paths []string
+	var errs ConfigError
+	for _, pattern := range patterns {
+		pattern = os.ExpandEnv(pattern)
+		if fsys != nil {
+			pattern = path.Join(path.Dir(file), pattern) // the paths of io/fs are slash-separated
+		} else if strings.HasPrefix(pattern, "~") {
+			pattern, _ = NormalizePath(pattern)
+		} else if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(file), pattern)
+		}
+		matches, err := globConfigFiles(fsys, pattern)
+		if err != nil {
+			errs.Entries = append(errs.Entries, &ConfigErrorEntry{Kind: ErrConfigSyntax, Source: SourceFile,
+				File: file, Name: ConfigIncludeKey, Value: pattern, Err: err})
+			continue
+		}
+		if len(matches) == 0 && !strings.ContainsAny(pattern, "*?[") {
+			errs.Entries = append(errs.Entries, &ConfigErrorEntry{Kind: ErrConfigNotFound, Source: SourceFile,
+				File: file, Name: ConfigIncludeKey, Value: pattern,
+				Err: fmt.Errorf("included file '%v' not found", pattern)})
+			continue
+		}
+		for _, match := range matches {
+			if !isConfigDir(fsys, match) {
+				paths = append(paths, match)
+			}
+		}
+	}
+	return paths, errs.err()
+}
+
+// getDropInFiles returns the files of drop-in directory of config file 'file' in lexical order.
+// The drop-in directory is '<file>.d', only the files with the same extension
+// as config file are loaded: 'config.toml.d/10-network.toml', 'config.toml.d/20-logging.toml'.
+// The files without extension are matched by '*.toml' pattern.
+// The directory is searched in 'fsys' or in OS file system if 'fsys' is 'nil'.
+func getDropInFiles(fsys fs.FS, file string) []string {
+	ext := filepath.Ext(file)
+	if ext == "" {
+		ext = ".toml"
+	}
+	pattern := filepath.Join(file+".d", "*"+ext)
+	if fsys != nil {
+		pattern = path.Join(file+".d", "*"+ext)
+	}
+	matches, _ := globConfigFiles(fsys, pattern)
+	var paths []string
+	for _, match := range matches {
+		if !isConfigDir(fsys, match) {
+			paths = append(paths, match)
+		}
+	}
+	return paths
+}
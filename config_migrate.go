@@ -0,0 +1,455 @@
+package yagolib
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+)
+
+// ConfigVersionKey is the config file key holding the version of config layout, see WithMigration.
+// The file without the key has version 0.
+var ConfigVersionKey = "config_version"
+
+// ConfigMigrationFunc upgrades decoded config file 'm' from the previous version of config layout.
+// It may add, rename, convert and delete the keys of 'm', the tables are nested maps.
+type ConfigMigrationFunc func(m map[string]interface{}) error
+
+type configMigration struct {
+	version int
+	migrate ConfigMigrationFunc
+}
+
+// WithMigration registers the migration of config files to 'version' of config layout:
+//
+//	loader := yagolib.NewLoader(yagolib.WithHomeConfig("config.toml"),
+//		yagolib.WithMigration(2, func(m map[string]interface{}) error {
+//			if verbose, _ := m["verbose"].(bool); verbose {
+//				m["log_level"] = "debug"
+//			}
+//			delete(m, "verbose")
+//			return nil
+//		}))
+//
+// The config file which ConfigVersionKey is less than 'version' is migrated before it is decoded into
+// the config structure. The migrations are applied in the order of versions, then the key is set
+// to the latest version, so the config structure may have the field holding it:
+//
+//	ConfigVersion int `toml:"config_version" default:"2"`
+//
+// The included files and drop-in fragments are migrated separately, so the migration must tolerate
+// missing keys. The migration of every file is reported to the logger once per Loader.
+//
+// The simply renamed keys need no migration, they are mapped onto the fields with `deprecated:"old_name,hint"` tag.
+// The old name may be a dotted path relative to the table of the field: `deprecated:"server.port,use http.port"`.
+// The use of deprecated key is reported to the logger once, the hint is shown in the message
+// ("use 'new_name'" if it is omitted). See also WithMigrationRewrite.
+func WithMigration(version int, migrate ConfigMigrationFunc) LoaderOption {
+	return func(ld *Loader) {
+		migrations := append(append([]configMigration{}, ld.migrations...), configMigration{version, migrate})
+		sort.SliceStable(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+		ld.migrations = migrations
+	}
+}
+
+// WithMigrationRewrite makes Loader write the migrated TOML config files back (see WithMigration and
+// its `deprecated` tag), so the deprecation warnings are not repeated by the next start
+// of application. Only the changed keys are rewritten, the comments and formatting are kept
+// (see UpdateConfigFile). The files of other formats, embedded files and readers are never rewritten.
+func WithMigrationRewrite(rewrite bool) LoaderOption {
+	return func(ld *Loader) { ld.rewriteMigrated = rewrite }
+}
+
+// messageSet is the set of messages already reported by Loader, it is shared by all loadings.
+type messageSet struct {
+	mu       sync.Mutex
+	messages map[string]bool
+}
+
+// add adds 'msg' to the set and reports whether it is new.
+func (s *messageSet) add(msg string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.messages[msg] {
+		return false
+	}
+	if s.messages == nil {
+		s.messages = map[string]bool{}
+	}
+	s.messages[msg] = true
+	return true
+}
+
+// warnOnce reports the warning to the logger if the Loader has not reported it yet.
+func (l *configLoader) warnOnce(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if l.warned == nil || l.warned.add(msg) {
+		l.warnf("%v", msg)
+	}
+}
+
+// deprecatedKey is the deprecated config key found in config file.
+type deprecatedKey struct {
+	old  string // path of the deprecated key: "server.verbosity"
+	hint string // "use 'log_level'"
+}
+
+// hasDeprecatedFields reports whether 'structType' or its nested structures have the fields with `deprecated` tag.
+func hasDeprecatedFields(structType reflect.Type, visited map[reflect.Type]bool) bool {
+	for structType.Kind() == reflect.Ptr || structType.Kind() == reflect.Slice ||
+		structType.Kind() == reflect.Array || structType.Kind() == reflect.Map {
+		structType = structType.Elem()
+	}
+	if !isNestedStruct(reflect.Zero(structType)) || visited[structType] {
+		return false
+	}
+	if visited == nil {
+		visited = map[reflect.Type]bool{}
+	}
+	visited[structType] = true
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		if _, ok := field.Tag.Lookup("deprecated"); ok && field.PkgPath == "" {
+			return true
+		}
+		if field.PkgPath == "" && hasDeprecatedFields(field.Type, visited) {
+			return true
+		}
+	}
+	return false
+}
+
+// renameDeprecatedKeys moves the values of deprecated keys of decoded config file 'm' to the keys of
+// the fields of 'structType' having `deprecated:"old_name,hint"` tag. The value of deprecated key
+// is dropped if the key of the field is set too. 'prefix' is the path of 'm' keys.
+// The renamed keys are returned sorted.
+func renameDeprecatedKeys(structType reflect.Type, m map[string]interface{}, prefix []string) []deprecatedKey {
+	var renamed []deprecatedKey
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		tag, ok := field.Tag.Lookup("deprecated")
+		if !ok || field.PkgPath != "" {
+			continue
+		}
+		parts := strings.SplitN(tag, ",", 2)
+		oldKey := splitTOMLKey(parts[0])
+		value, ok := removeConfigMapKey(m, oldKey)
+		if !ok {
+			continue
+		}
+		key := getFieldKey(field)
+		isSet := false
+		for k := range m {
+			isSet = isSet || IsFieldNameMatch(field, k)
+		}
+		if !isSet {
+			m[key] = value
+		}
+		hint := fmt.Sprintf("use '%v'", strings.Join(append(append([]string{}, prefix...), key), "."))
+		if len(parts) > 1 && strings.TrimSpace(parts[1]) != "" {
+			hint = strings.TrimSpace(parts[1])
+		}
+		renamed = append(renamed, deprecatedKey{old: strings.Join(append(append([]string{}, prefix...), oldKey...), "."),
+			hint: hint})
+	}
+	for key, value := range m {
+		fieldType, ok := getKeyStructType(structType, []string{key})
+		if !ok {
+			continue
+		}
+		path := append(append([]string{}, prefix...), key)
+		switch v := value.(type) {
+		case map[string]interface{}:
+			renamed = append(renamed, renameDeprecatedKeys(fieldType, v, path)...)
+		case []map[string]interface{}:
+			for _, item := range v {
+				renamed = append(renamed, renameDeprecatedKeys(fieldType, item, path)...)
+			}
+		case []interface{}:
+			for _, item := range v {
+				if itemMap, ok := item.(map[string]interface{}); ok {
+					renamed = append(renamed, renameDeprecatedKeys(fieldType, itemMap, path)...)
+				}
+			}
+		}
+	}
+	sort.Slice(renamed, func(i, j int) bool { return renamed[i].old < renamed[j].old })
+	return renamed
+}
+
+// removeConfigMapKey removes the value of 'key' path from decoded config map 'm' and returns it.
+// The keys are matched like IsFieldNameMatch does. The tables left empty are removed too.
+func removeConfigMapKey(m map[string]interface{}, key []string) (interface{}, bool) {
+	normKey := RemoveCharacters(key[0], "-_ ")
+	for k, value := range m {
+		if !strings.EqualFold(RemoveCharacters(k, "-_ "), normKey) {
+			continue
+		}
+		if len(key) == 1 {
+			delete(m, k)
+			return value, true
+		}
+		table, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		value, ok = removeConfigMapKey(table, key[1:])
+		if ok && len(table) == 0 {
+			delete(m, k)
+		}
+		return value, ok
+	}
+	return nil, false
+}
+
+// getConfigVersion returns the value of ConfigVersionKey of decoded config map 'm' and the key itself,
+// empty key if it is missing.
+func getConfigVersion(m map[string]interface{}) (int, string, error) {
+	for key, value := range m {
+		if strings.EqualFold(key, ConfigVersionKey) {
+			var version int
+			if err := TryToConvert(value, &version, nil); err != nil {
+				return 0, key, fmt.Errorf("'%v' must be an integer: %v", key, err)
+			}
+			return version, key, nil
+		}
+	}
+	return 0, "", nil
+}
+
+// migrateMap applies the migrations (see WithMigration) and renames the deprecated keys of decoded
+// config file 'path'. It reports whether 'm' is changed.
+func (l *configLoader) migrateMap(path string, m map[string]interface{}) (bool, error) {
+	changed := false
+	if len(l.migrations) > 0 {
+		version, versionKey, err := getConfigVersion(m)
+		if err != nil {
+			return false, err
+		}
+		if latest := l.migrations[len(l.migrations)-1].version; version < latest {
+			for _, migration := range l.migrations {
+				if migration.version <= version {
+					continue
+				}
+				if err = migration.migrate(m); err != nil {
+					return false, fmt.Errorf("migration to version %v failed: %w", migration.version, err)
+				}
+			}
+			delete(m, versionKey)
+			m[ConfigVersionKey] = latest
+			l.warnOnce("Config file '%v' of version %v is migrated to version %v", path, version, latest)
+			changed = true
+		}
+	}
+	structType := reflect.TypeOf(l.config).Elem()
+	renamed := renameDeprecatedKeys(structType, m, nil)
+	for name, table := range getProfileTables(m) {
+		renamed = append(renamed, renameDeprecatedKeys(structType, table, []string{ConfigProfileKey, name})...)
+	}
+	for _, k := range renamed {
+		l.warnOnce("Config key '%v' of '%v' is deprecated, %v", k.old, path, k.hint)
+	}
+	return changed || len(renamed) > 0, nil
+}
+
+// migratedConfig is the config file decoded before and after migration, see getMigratingDecoder.
+type migratedConfig struct {
+	data     []byte
+	original map[string]interface{}
+	migrated map[string]interface{}
+	changed  bool
+}
+
+// getMigratingDecoder returns the decoder of config file 'path' which migrates the map decoded
+// by 'decoder' (see migrateMap). The migrated map is stored to the config structure
+// by ParseMapToStruct rules. The file is migrated once, the following calls with the same
+// data reuse the migrated map. The file changed by migration is added to 'l.migrated'.
+func (l *configLoader) getMigratingDecoder(path string, decoder ConfigDecoder) ConfigDecoder {
+	var mc *migratedConfig
+	return func(data []byte, v interface{}) error {
+		if mc == nil || !bytes.Equal(mc.data, data) {
+			var m map[string]interface{}
+			if err := decoder(data, &m); err != nil {
+				return err
+			}
+			original := copyConfigMap(m)
+			changed, err := l.migrateMap(path, m)
+			if err != nil {
+				return err
+			}
+			mc = &migratedConfig{data: data, original: original, migrated: m, changed: changed}
+			if changed {
+				if l.migrated == nil {
+					l.migrated = map[string]*migratedConfig{}
+				}
+				l.migrated[path] = mc
+			}
+		}
+		if _, isMap := v.(*map[string]interface{}); !isMap && !mc.changed {
+			return decoder(data, v)
+		}
+		return storeConfigMap(copyConfigMap(mc.migrated), v)
+	}
+}
+
+// rewriteMigratedFile writes the migrated content of TOML config file 'path' back to the file,
+// see WithMigrationRewrite.
+func (l *configLoader) rewriteMigratedFile(path string, mc *migratedConfig) {
+	if getConfigFormatName(path, l.format) != "toml" {
+		l.warnOnce("Migrated config file '%v' is not rewritten: only TOML files may be rewritten", path)
+		return
+	}
+	text, err := rewriteTOMLKeys(string(mc.data), mc.original, mc.migrated)
+	if err == nil {
+		_, err = toml.Decode(text, &map[string]interface{}{}) // never write broken file
+	}
+	var info os.FileInfo
+	if err == nil {
+		info, err = os.Stat(path)
+	}
+	if err == nil {
+		err = WriteFileAtomic(path, []byte(text), info.Mode().Perm())
+	}
+	if err != nil {
+		l.warnOnce("Can't rewrite migrated config file '%v': %v", path, err)
+		return
+	}
+	l.infof("Migrated config file '%v' is rewritten", path)
+}
+
+// rewriteTOMLKeys changes TOML text decoded to 'original' map so it is decoded to 'migrated' map:
+// the missing keys are removed, the changed and new keys are set. The new key having the value
+// of removed key of the same table replaces the removed key in place, so its comments are kept.
+func rewriteTOMLKeys(text string, original, migrated map[string]interface{}) (string, error) {
+	before, after := map[string]interface{}{}, map[string]interface{}{}
+	flattenTOMLKeys(original, nil, before)
+	flattenTOMLKeys(migrated, nil, after)
+	var removed, changed []string
+	for key := range before {
+		if _, ok := after[key]; !ok {
+			removed = append(removed, key)
+		}
+	}
+	for key, value := range after {
+		if old, ok := before[key]; !ok || !reflect.DeepEqual(old, value) {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(removed)
+	sort.Strings(changed)
+	var err error
+	renamed := map[string]bool{}
+	for _, key := range removed {
+		for _, newKey := range changed {
+			if _, ok := before[newKey]; ok || renamed[newKey] || !reflect.DeepEqual(before[key], after[newKey]) {
+				continue
+			}
+			value, err := FormatTOMLValue(after[newKey])
+			if err != nil {
+				continue
+			}
+			var ok bool
+			if text, ok, err = renameTOMLKey(text, splitTOMLKey(key), splitTOMLKey(newKey), value); err != nil {
+				return "", err
+			}
+			if ok {
+				renamed[key], renamed[newKey] = true, true
+				break
+			}
+		}
+		if renamed[key] {
+			continue
+		}
+		if text, err = removeTOMLKey(text, splitTOMLKey(key)); err != nil {
+			return "", err
+		}
+	}
+	if text, err = removeEmptyTOMLTables(text, after); err != nil {
+		return "", err
+	}
+	for _, key := range changed {
+		if renamed[key] {
+			continue
+		}
+		value, err := FormatTOMLValue(after[key])
+		if err != nil {
+			return "", fmt.Errorf("Can't write key '%v': %v", key, err)
+		}
+		if text, err = setTOMLKey(text, splitTOMLKey(key), value); err != nil {
+			return "", fmt.Errorf("Can't write key '%v': %v", key, err)
+		}
+	}
+	return text, nil
+}
+
+// renameTOMLKey replaces 'oldKey = value' pair of TOML text by 'newKey = value' keeping the comments
+// and the position of pair. It reports 'false' if 'newKey' is not a key of the same table.
+func renameTOMLKey(text string, oldKey, newKey []string, value string) (string, bool, error) {
+	_, pairs, err := parseTOMLLayout(text)
+	if err != nil {
+		return text, false, err
+	}
+	for _, pair := range pairs {
+		if pair.inArray || !isTOMLKeyEqual(append(append([]string{}, pair.table...), pair.key...), oldKey) {
+			continue
+		}
+		if len(newKey) != len(oldKey) || !isTOMLKeyEqual(newKey[:len(pair.table)], pair.table) {
+			return text, false, nil
+		}
+		keyStart := skipTOMLSpaces(text, strings.LastIndexByte(text[:pair.valueStart], '\n')+1)
+		return text[:keyStart] + formatTOMLKey(newKey[len(pair.table):]) + " = " + value + text[pair.valueEnd:], true, nil
+	}
+	return text, false, fmt.Errorf("key '%v' can't be renamed", formatTOMLKey(oldKey))
+}
+
+// flattenTOMLKeys stores the values of nested maps of 'm' to 'flat' by the dotted TOML key paths.
+func flattenTOMLKeys(m map[string]interface{}, prefix []string, flat map[string]interface{}) {
+	for key, value := range m {
+		path := append(append([]string{}, prefix...), key)
+		if table, ok := value.(map[string]interface{}); ok && len(table) > 0 {
+			flattenTOMLKeys(table, path, flat)
+		} else {
+			flat[formatTOMLKey(path)] = value
+		}
+	}
+}
+
+// removeEmptyTOMLTables removes the headers of tables which have no keys in TOML text
+// and no keys in 'flat' map of the new values (see flattenTOMLKeys).
+func removeEmptyTOMLTables(text string, flat map[string]interface{}) (string, error) {
+	tables, pairs, err := parseTOMLLayout(text)
+	if err != nil {
+		return text, err
+	}
+	isEmpty := func(table *tomlTable) bool {
+		for _, pair := range pairs {
+			if !pair.inArray && len(pair.table) >= len(table.path) &&
+				isTOMLKeyEqual(pair.table[:len(table.path)], table.path) {
+				return false
+			}
+		}
+		for key := range flat {
+			if path := splitTOMLKey(key); len(path) > len(table.path) && isTOMLKeyEqual(path[:len(table.path)], table.path) {
+				return false
+			}
+		}
+		return true
+	}
+	for i := len(tables) - 1; i > 0; i-- { // from the end, so the offsets of preceding tables are valid
+		table := tables[i]
+		if table.isArray || !isEmpty(table) {
+			continue
+		}
+		start := table.start
+		if strings.HasSuffix(text[:start], "\n\n") {
+			start-- // the blank line before the header
+		}
+		text = text[:start] + text[table.end:]
+	}
+	return text, nil
+}
@@ -0,0 +1,187 @@
+package yagolib
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// ConfigProfileKey is the name of config file table containing the profiles:
+//
+//	port = 8080
+//
+//	[profile.dev]
+//	port = 8081
+//
+//	[profile.staging]
+//	inherits = "dev"
+//	log_level = "debug"
+//
+// The table of the active profile is applied on top of the other keys of the same file,
+// before the next config file or layer. A profile may inherit other profiles by 'inherits' key
+// (the name or the list of names), the inherited profiles of the same file are applied first.
+// Set the variable to "" to disable profiles.
+var ConfigProfileKey = "profile"
+
+// ConfigProfile is the name of profile used when it is not selected by the command line
+// or environment variable (see WithProfile).
+var ConfigProfile string
+
+// configProfileInheritsKey is the key of profile table listing the inherited profiles.
+const configProfileInheritsKey = "inherits"
+
+// WithProfile selects the config profile (see ConfigProfileKey). The profile may be overridden
+// by '--profile <name>' command line flag or '<PREFIX>_PROFILE' environment variable.
+func WithProfile(name string) LoaderOption {
+	return func(ld *Loader) { ld.profile = name }
+}
+
+// selectProfile returns the active profile: the value of '--profile' command line flag,
+// '<PREFIX>_PROFILE' environment variable or the profile given by option.
+// The flag is removed from the returned command line unless the config structure has 'profile' flag itself.
+func (l *configLoader) selectProfile() (string, []string) {
+	profile := l.profile
+	if prefix := l.getEnvPrefix(); prefix != "" && l.envPrefix != "-" {
+		if value, ok := os.LookupEnv(prefix + "_PROFILE"); ok && value != "" {
+			profile = value
+		}
+	}
+	cmdLine := l.cmdLine
+	for i := 0; i < len(cmdLine); i++ {
+		arg := cmdLine[i]
+		if arg == "--" {
+			break
+		}
+		value, n := "", 0
+		switch {
+		case arg == "--profile" && i+1 < len(cmdLine):
+			value, n = cmdLine[i+1], 2
+		case strings.HasPrefix(arg, "--profile="):
+			value, n = arg[len("--profile="):], 1
+		default:
+			continue
+		}
+		profile = value
+		if findCmdLineFlag(getCmdLineFlags(reflect.ValueOf(l.config).Elem(), "", ""), "profile") == nil {
+			cmdLine = append(append([]string{}, cmdLine[:i]...), cmdLine[i+n:]...)
+			i--
+		} else {
+			i += n - 1
+		}
+	}
+	return profile, cmdLine
+}
+
+// profileOverlay is the table of config profile.
+type profileOverlay struct {
+	name   string
+	values map[string]interface{}
+}
+
+// getProfileTables returns all profile tables of decoded config file 'm'.
+func getProfileTables(m map[string]interface{}) map[string]map[string]interface{} {
+	if ConfigProfileKey == "" {
+		return nil
+	}
+	for key, value := range m {
+		if !strings.EqualFold(key, ConfigProfileKey) {
+			continue
+		}
+		tables := map[string]map[string]interface{}{}
+		if profiles, ok := value.(map[string]interface{}); ok {
+			for name, table := range profiles {
+				if t, ok := table.(map[string]interface{}); ok {
+					tables[name] = t
+				}
+			}
+		}
+		return tables
+	}
+	return nil
+}
+
+// getProfileOverlays returns the tables of profile 'name' and of the profiles it inherits
+// in the order of applying.
+func getProfileOverlays(tables map[string]map[string]interface{}, name string) ([]profileOverlay, error) {
+	var overlays []profileOverlay
+	applied := map[string]bool{}
+	var chain []string
+	var walk func(name string) error
+	walk = func(name string) error {
+		for i, n := range chain {
+			if n == name {
+				return fmt.Errorf("profile inheritance cycle: '%v'", strings.Join(append(chain[i:], name), "' -> '"))
+			}
+		}
+		if applied[name] {
+			return nil
+		}
+		table, ok := tables[name]
+		if !ok {
+			return fmt.Errorf("profile '%v' is not defined", name)
+		}
+		chain = append(chain, name)
+		var parents []string
+		switch v := table[configProfileInheritsKey].(type) {
+		case string:
+			parents = []string{v}
+		case []interface{}:
+			for _, item := range v {
+				parents = append(parents, fmt.Sprint(item))
+			}
+		}
+		for _, parent := range parents {
+			if err := walk(parent); err != nil {
+				return err
+			}
+		}
+		chain = chain[:len(chain)-1]
+		applied[name] = true
+		values := map[string]interface{}{}
+		for k, v := range table {
+			if k != configProfileInheritsKey {
+				values[k] = v
+			}
+		}
+		overlays = append(overlays, profileOverlay{name: name, values: values})
+		return nil
+	}
+	err := walk(name)
+	return overlays, err
+}
+
+// applyProfile applies the overlay of the active profile defined in config file 'path'.
+func (l *configLoader) applyProfile(path, origin string, data []byte, decoder ConfigDecoder) {
+	var m map[string]interface{}
+	if l.activeProfile == "" || decoder(data, &m) != nil {
+		return
+	}
+	tables := getProfileTables(m)
+	if _, ok := tables[l.activeProfile]; !ok {
+		return
+	}
+	l.profileFound = true
+	overlays, err := getProfileOverlays(tables, l.activeProfile)
+	if err != nil {
+		l.addError(err, &ConfigErrorEntry{Kind: ErrConfigValue, Source: SourceFile, File: path, Origin: origin,
+			Name: ConfigProfileKey + "." + l.activeProfile})
+		return
+	}
+	for _, overlay := range overlays {
+		l.infof("Applying profile '%v' of '%v'", overlay.name, path)
+		before := l.snapshot()
+		_, err := parseMapToStruct(overlay.values, l.config)
+		if err == nil && l.entryDefaults {
+			var errs ConfigError
+			setEntryDefaults(reflect.ValueOf(l.config).Elem(), overlay.values, false, "", &errs)
+			err = errs.err()
+		}
+		if err != nil {
+			l.addError(err, &ConfigErrorEntry{Kind: ErrConfigValue, Source: SourceFile, File: path, Origin: origin,
+				Name: ConfigProfileKey + "." + overlay.name})
+			continue
+		}
+		l.trackMap(path, data, overlay.values, ConfigProfileKey+"."+overlay.name+".", overlay.name, before)
+	}
+}
@@ -0,0 +1,311 @@
+package yagolib
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigSource is the kind of config data source.
+type ConfigSource int
+
+const (
+	// SourceDefault means the field keeps the value set before loading: `default` tag or code.
+	SourceDefault ConfigSource = iota
+	// SourceFile means the value is read from config file.
+	SourceFile
+	// SourceEnv means the value is read from environment variable.
+	SourceEnv
+	// SourceCmdLine means the value is read from command line.
+	SourceCmdLine
+)
+
+func (s ConfigSource) String() string {
+	switch s {
+	case SourceDefault:
+		return "default"
+	case SourceFile:
+		return "file"
+	case SourceEnv:
+		return "env"
+	case SourceCmdLine:
+		return "cmdline"
+	}
+	return fmt.Sprintf("ConfigSource(%d)", int(s))
+}
+
+// FieldOrigin describes where the value of config field came from.
+type FieldOrigin struct {
+	Source ConfigSource
+	File   string      // path of config file for SourceFile
+	Line   int         // position of the key in config file, 0 if unknown
+	Column int         // (YAML, TOML, INI and dotenv files only)
+	Name   string      // name of config file key, environment variable or command line flag
+	Value  interface{} // the final value of the field
+	// Profile is the name of config profile if the value is set by profile overlay (see ConfigProfileKey)
+	Profile string
+	// Redacted is 'true' if the value is resolved from a reference and must not be shown
+	Redacted bool
+}
+
+// Location returns human readable location of the value: "path:line:column",
+// the name of environment variable or command line flag.
+func (o *FieldOrigin) Location() string {
+	switch {
+	case o.File != "" && o.Line > 0:
+		return fmt.Sprintf("%v:%v:%v", o.File, o.Line, o.Column)
+	case o.File != "":
+		return o.File
+	}
+	return o.Name
+}
+
+// Provenance maps the path of every config field ("DB.Host") to the origin of its value.
+type Provenance map[string]*FieldOrigin
+
+// PrintProvenance writes the provenance as a table sorted by field paths.
+// It is intended for '--show-config' like options of applications:
+//
+//	prov, err := yagolib.LoadConfigWithProvenance(&config, "config.toml", "", os.Args[1:], false)
+//	if config.ShowConfig {
+//		yagolib.PrintProvenance(os.Stdout, prov)
+//	}
+func PrintProvenance(w io.Writer, prov Provenance) error {
+	paths := make([]string, 0, len(prov))
+	for path := range prov {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "FIELD\tVALUE\tSOURCE\tLOCATION")
+	for _, path := range paths {
+		o := prov[path]
+		var value interface{} = o.Value
+		if o.Redacted {
+			value = redactedValue
+		}
+		source := o.Source.String()
+		if o.Profile != "" {
+			source += " (profile '" + o.Profile + "')"
+		}
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\n", path, value, source, o.Location())
+	}
+	return tw.Flush()
+}
+
+// getLeafFieldPaths returns the paths of all fields of structure except nested structures themselves.
+func getLeafFieldPaths(structValue reflect.Value, prefix string) []string {
+	var paths []string
+	structType := structValue.Type()
+	for i := 0; i < structType.NumField(); i++ {
+		fieldValue := structValue.Field(i)
+		if !fieldValue.CanInterface() {
+			continue
+		}
+		path := prefix + structType.Field(i).Name
+		if isNestedStruct(fieldValue) {
+			paths = append(paths, getLeafFieldPaths(fieldValue, path+".")...)
+		} else {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// getFieldByPath returns the field of structure by path like "DB.Host".
+func getFieldByPath(structValue reflect.Value, path string) reflect.Value {
+	for _, name := range strings.Split(path, ".") {
+		structValue = reflect.Indirect(structValue)
+		if structValue.Kind() != reflect.Struct {
+			return reflect.Value{}
+		}
+		structValue = structValue.FieldByName(name)
+	}
+	return structValue
+}
+
+// trackDefaults marks all fields as set by default.
+func (l *configLoader) trackDefaults() {
+	if l.prov == nil {
+		return
+	}
+	for _, path := range getLeafFieldPaths(reflect.ValueOf(l.config).Elem(), "") {
+		l.prov[path] = &FieldOrigin{Source: SourceDefault}
+	}
+}
+
+// snapshot returns the copy of config to find the fields changed by the next source.
+func (l *configLoader) snapshot() reflect.Value {
+	if l.prov == nil {
+		return reflect.Value{}
+	}
+	return copyConfig(l.config)
+}
+
+// trackFile records the fields set by config file: the fields named by the file keys
+// and the fields changed since 'before' snapshot.
+func (l *configLoader) trackFile(path string, data []byte, decoder ConfigDecoder, before reflect.Value) {
+	if l.prov == nil {
+		return
+	}
+	var m map[string]interface{}
+	decoder(data, &m)
+	l.trackMap(path, data, m, "", "", before)
+}
+
+// trackMap records the fields set by decoded map 'm' of config file, 'keyPrefix' is the path of 'm' keys
+// in the file. 'profile' is the name of profile if 'm' is a profile overlay.
+func (l *configLoader) trackMap(path string, data []byte, m map[string]interface{}, keyPrefix, profile string,
+	before reflect.Value) {
+	if l.prov == nil {
+		return
+	}
+	keys := map[string]string{} // field path -> key path
+	mapKeysToFieldPaths(reflect.TypeOf(l.config).Elem(), m, keyPrefix, "", keys)
+	for _, field := range GetChangedFields(before.Addr().Interface(), l.config) {
+		if _, ok := keys[field]; !ok {
+			keys[field] = ""
+		}
+	}
+	positions := findKeyPositions(data, getConfigFormatName(path, l.format))
+	for field, key := range keys {
+		origin := &FieldOrigin{Source: SourceFile, File: path, Name: key, Profile: profile}
+		if pos, ok := positions[key]; ok {
+			origin.Line, origin.Column = pos[0], pos[1]
+		}
+		l.prov[field] = origin
+	}
+}
+
+// trackAppliedValues records the fields set by environment variables or command line.
+func (l *configLoader) trackAppliedValues(source ConfigSource, values []appliedValue) {
+	if l.prov == nil {
+		return
+	}
+	for _, v := range values {
+		l.prov[v.path] = &FieldOrigin{Source: source, Name: v.name}
+	}
+}
+
+// trackFinalValues stores the resulting values of fields to the provenance.
+func (l *configLoader) trackFinalValues() {
+	if l.prov == nil {
+		return
+	}
+	for _, path := range l.redacted {
+		if origin := l.prov[path]; origin != nil {
+			origin.Redacted = true
+		}
+	}
+	structValue := reflect.ValueOf(l.config).Elem()
+	for path, origin := range l.prov {
+		if field := getFieldByPath(structValue, path); field.IsValid() && field.CanInterface() {
+			origin.Value = field.Interface()
+		}
+	}
+}
+
+// mapKeysToFieldPaths finds the structure fields matching the keys of decoded config map.
+// The result maps the field path ("DB.Host") to the key path ("db.host").
+func mapKeysToFieldPaths(structType reflect.Type, m map[string]interface{}, keyPrefix, pathPrefix string,
+	result map[string]string) {
+	for key, value := range m {
+		for i := 0; i < structType.NumField(); i++ {
+			field := structType.Field(i)
+			if !IsFieldNameMatch(field, key) {
+				continue
+			}
+			fieldType := field.Type
+			if fieldType.Kind() == reflect.Ptr {
+				fieldType = fieldType.Elem()
+			}
+			if subMap, ok := value.(map[string]interface{}); ok && isNestedStruct(reflect.Zero(fieldType)) {
+				mapKeysToFieldPaths(fieldType, subMap, keyPrefix+key+".", pathPrefix+field.Name+".", result)
+			} else {
+				result[pathPrefix+field.Name] = keyPrefix + key
+			}
+			break
+		}
+	}
+}
+
+// getConfigFormatName returns the format of config file: 'format' if it is set or the file extension.
+func getConfigFormatName(path, format string) string {
+	if format == "" {
+		format = filepath.Ext(path)
+	}
+	return strings.ToLower(strings.TrimPrefix(format, "."))
+}
+
+// findKeyPositions returns the line and column of every key of config file
+// by the key path ("db.host"). JSON files are not supported.
+func findKeyPositions(data []byte, format string) map[string][2]int {
+	positions := map[string][2]int{}
+	switch format {
+	case "json":
+		return positions
+	case "yaml", "yml":
+		var root yaml.Node
+		if yaml.Unmarshal(data, &root) == nil && len(root.Content) > 0 {
+			findYAMLKeyPositions(root.Content[0], "", positions)
+		}
+		return positions
+	}
+	// TOML, INI and dotenv: 'key = value' lines grouped by '[table]' headers
+	table := ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, ";") {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "[") {
+			table = strings.TrimSpace(strings.Trim(strings.SplitN(trimmed, "]", 2)[0], "[ "))
+			if _, ok := positions[table]; !ok && table != "" {
+				positions[table] = [2]int{lineNum, strings.Index(line, trimmed) + 1}
+			}
+			if table != "" {
+				table += "."
+			}
+			continue
+		}
+		i := strings.IndexAny(trimmed, "=:")
+		if i <= 0 {
+			continue
+		}
+		key := strings.TrimSpace(strings.TrimPrefix(trimmed[:i], "export "))
+		key = strings.Trim(key, `"'`)
+		if _, ok := positions[table+key]; !ok {
+			positions[table+key] = [2]int{lineNum, strings.Index(line, trimmed) + 1}
+		}
+	}
+	return positions
+}
+
+// findYAMLKeyPositions stores the positions of mapping keys, the keys of sequence items
+// have the path of sequence itself ("servers.host"), the first item wins.
+func findYAMLKeyPositions(node *yaml.Node, prefix string, positions map[string][2]int) {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if _, ok := positions[prefix+key.Value]; !ok {
+				positions[prefix+key.Value] = [2]int{key.Line, key.Column}
+			}
+			findYAMLKeyPositions(value, prefix+key.Value+".", positions)
+		}
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			findYAMLKeyPositions(item, prefix, positions)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package yagolib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// ConfigResolveReferences enables the resolution of references in string values done by LoadConfig,
+// see ResolveConfigValue. The resolution is opt-in: it is disabled by default because the values
+// of existing configs like "$HOME" or "file:name" would change their meaning.
+var ConfigResolveReferences = false
+
+// redactedValue replaces the resolved values in verbose output and PrintProvenance.
+const redactedValue = "******"
+
+// ResolveConfigValue resolves the references in config value 'value' using 'lookup'
+// to get environment variables ('nil' means os.LookupEnv):
+//
+//	${VAR}          - the value of environment variable VAR, it is an error if VAR is not set
+//	${VAR:-default} - the value of VAR or 'default' if VAR is not set or empty
+//	$$              - '$' character
+//	file:/path      - the content of file '/path' with leading and trailing spaces trimmed
+//	@/path          - the same as 'file:/path', the path must start with '/', '.' or '~'
+//
+// The variables are substituted in any part of the value, file references must be the whole value
+// (the path itself may contain variables: 'file:${SECRETS_DIR}/db_password').
+// The output of commands is not supported as a reference: anyone who can edit a config file
+// could run programs with the rights of application.
+// The function returns the resolved value and 'true' if the value contained references.
+func ResolveConfigValue(value string, lookup func(string) (string, bool)) (string, bool, error) {
+	if lookup == nil {
+		lookup = os.LookupEnv
+	}
+	resolved, changed, err := expandConfigVars(value, lookup)
+	if err != nil {
+		return value, false, err
+	}
+	path := ""
+	switch {
+	case strings.HasPrefix(resolved, "file:"):
+		path = resolved[len("file:"):]
+	case len(resolved) > 1 && resolved[0] == '@' && strings.ContainsRune("/.~", rune(resolved[1])):
+		path = resolved[1:]
+	default:
+		return resolved, changed, nil
+	}
+	if path, err = NormalizePath(path); err != nil {
+		return value, false, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return value, false, err
+	}
+	return strings.TrimSpace(string(data)), true, nil
+}
+
+// expandConfigVars substitutes ${VAR} and ${VAR:-default} references.
+func expandConfigVars(value string, lookup func(string) (string, bool)) (string, bool, error) {
+	if !strings.Contains(value, "$") {
+		return value, false, nil
+	}
+	var sb strings.Builder
+	changed := false
+	for i := 0; i < len(value); i++ {
+		if value[i] != '$' || i+1 == len(value) {
+			sb.WriteByte(value[i])
+			continue
+		}
+		switch value[i+1] {
+		case '$':
+			sb.WriteByte('$')
+			i++
+			changed = true
+			continue
+		case '{':
+		default:
+			sb.WriteByte(value[i])
+			continue
+		}
+		end := strings.IndexByte(value[i:], '}')
+		if end < 0 {
+			return value, false, fmt.Errorf("Unclosed variable reference in '%v'", value)
+		}
+		expr := value[i+2 : i+end]
+		name, def, hasDefault := expr, "", false
+		if j := strings.Index(expr, ":-"); j >= 0 {
+			name, def, hasDefault = expr[:j], expr[j+2:], true
+		}
+		if name == "" {
+			return value, false, fmt.Errorf("Empty variable name in '%v'", value)
+		}
+		v, ok := lookup(name)
+		switch {
+		case hasDefault && v == "":
+			v = def
+		case !ok:
+			return value, false, fmt.Errorf("Environment variable '%v' is not set", name)
+		}
+		sb.WriteString(v)
+		i += end
+		changed = true
+	}
+	return sb.String(), changed, nil
+}
+
+// resolveStructValues resolves the references in all string fields of structure,
+// including nested structures and slices of strings. It returns the paths of fields
+// which values are resolved ("DB.Password", "Tags" for any item of slice).
+func resolveStructValues(structValue reflect.Value, lookup func(string) (string, bool)) ([]string, error) {
+	var resolved []string
+	var errs ConfigError
+	resolve := func(value reflect.Value, path, fieldPath string) {
+		s, changed, err := ResolveConfigValue(value.String(), lookup)
+		if err != nil {
+			errs.Entries = append(errs.Entries, &ConfigErrorEntry{Kind: ErrConfigValue, Field: path,
+				Value: value.String(), Err: err})
+		} else if changed {
+			value.SetString(s)
+			if len(resolved) == 0 || resolved[len(resolved)-1] != fieldPath {
+				resolved = append(resolved, fieldPath)
+			}
+		}
+	}
+	var walk func(structValue reflect.Value, prefix string)
+	walk = func(structValue reflect.Value, prefix string) {
+		structType := structValue.Type()
+		for i := 0; i < structType.NumField(); i++ {
+			fieldValue := structValue.Field(i)
+			if !fieldValue.CanSet() {
+				continue
+			}
+			path := prefix + structType.Field(i).Name
+			if fieldValue.Kind() == reflect.Ptr && !fieldValue.IsNil() {
+				fieldValue = fieldValue.Elem()
+			}
+			switch {
+			case isNestedStruct(fieldValue):
+				walk(fieldValue, path+".")
+			case fieldValue.Kind() == reflect.String:
+				resolve(fieldValue, path, path)
+			case fieldValue.Kind() == reflect.Slice && fieldValue.Type().Elem().Kind() == reflect.String:
+				for j := 0; j < fieldValue.Len(); j++ {
+					resolve(fieldValue.Index(j), fmt.Sprintf("%v[%d]", path, j), path)
+				}
+			}
+		}
+	}
+	walk(structValue, "")
+	return resolved, errs.err()
+}
@@ -0,0 +1,1178 @@
+package yagolib
+
+import (
+	"encoding"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// SaveConfig writes the config structure pointed to by 'config' to TOML file 'path'.
+// Only the fields which values differ from the values currently stored in the file
+// (or from the defaults if the key is absent) are written, see UpdateConfigFile.
+// The names of keys are taken from `toml:"name"` tags or from the field names.
+// The fields with `toml:"-"` (or `json:"-"`, `yaml:"-"`) tag, command line commands and positional arguments
+// are not written.
+// The maps and the slices of structures are written as tables and arrays of tables (the names are spelled
+// as in the file), or as inline values if the file has 'key = value' pair for the field.
+// The existing tables are updated in place like the other keys, so their comments and the order
+// of keys are kept; only the tables of added and removed entries are written and removed.
+// The template entries of maps are kept (see ConfigTemplateKey).
+func SaveConfig(path string, config interface{}) error {
+	if config == nil || reflect.TypeOf(config).Kind() != reflect.Ptr ||
+		reflect.TypeOf(config).Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("'config' argument is not a pointer to structure. It has type: %T", config)
+	}
+	path, err := NormalizePath(path)
+	if err != nil {
+		return err
+	}
+	stored := reflect.New(reflect.TypeOf(config).Elem()).Interface()
+	if err = SetDefaults(stored); err != nil {
+		return err
+	}
+	structValue := reflect.ValueOf(config).Elem()
+	if IsFileExists(path) {
+		var m map[string]interface{}
+		if _, err = toml.DecodeFile(path, &m); err != nil {
+			return fmt.Errorf("Error parsing config file '%v':\n%v", path, err)
+		}
+		if hasMapTemplates(structValue.Type(), map[reflect.Type]bool{}) {
+			applyMapTemplates(structValue.Type(), m, "", map[string]map[string]interface{}{})
+			err = storeConfigMap(m, stored)
+		} else {
+			_, err = toml.DecodeFile(path, stored)
+		}
+		if err != nil {
+			return fmt.Errorf("Error parsing config file '%v':\n%v", path, err)
+		}
+	}
+	values := map[string]interface{}{}
+	sections := map[string]tomlSection{}
+	for _, fieldPath := range GetChangedFields(stored, config) {
+		field := getFieldByPath(structValue, fieldPath)
+		if field.Kind() == reflect.Ptr && field.IsNil() {
+			continue // TOML has no null value
+		}
+		key, ok := getTOMLKeyPath(structValue.Type(), fieldPath)
+		if !ok {
+			continue
+		}
+		if isTOMLSectionValue(field) {
+			section := tomlSection{value: field, old: getFieldByPath(reflect.ValueOf(stored).Elem(), fieldPath)}
+			if structField, ok := getStructFieldByPath(structValue.Type(), fieldPath); ok {
+				section.template, _ = getMapTemplateKey(structField)
+			}
+			sections[key] = section
+		} else {
+			values[key] = field.Interface()
+		}
+	}
+	if len(values) == 0 && len(sections) == 0 {
+		return nil
+	}
+	return updateConfigFile(path, values, sections)
+}
+
+// getTOMLKeyPath converts the path of structure field ("DB.MaxConn") to TOML key path
+// using the names from `toml` tags. It returns 'false' if the field is not written to TOML file
+// (see getTOMLFieldKey).
+func getTOMLKeyPath(structType reflect.Type, fieldPath string) (string, bool) {
+	var keys []string
+	for _, name := range strings.Split(fieldPath, ".") {
+		for structType.Kind() == reflect.Ptr {
+			structType = structType.Elem()
+		}
+		field, _ := structType.FieldByName(name)
+		key, ok := getTOMLFieldKey(field)
+		if !ok {
+			return "", false
+		}
+		keys = append(keys, key)
+		structType = field.Type
+	}
+	return strings.Join(keys, "."), true
+}
+
+// getStructFieldByPath returns the field of structure type by its path: "DB.MaxConn".
+func getStructFieldByPath(structType reflect.Type, fieldPath string) (reflect.StructField, bool) {
+	var field reflect.StructField
+	for _, name := range strings.Split(fieldPath, ".") {
+		for structType.Kind() == reflect.Ptr {
+			structType = structType.Elem()
+		}
+		if structType.Kind() != reflect.Struct {
+			return field, false
+		}
+		var ok bool
+		if field, ok = structType.FieldByName(name); !ok {
+			return field, false
+		}
+		structType = field.Type
+	}
+	return field, true
+}
+
+// getTOMLFieldKey returns the TOML key of structure field: the name from `toml` tag or the field name.
+// It returns 'false' for the fields excluded from config files (see isFieldExcluded), command line commands,
+// positional arguments and unexported fields.
+func getTOMLFieldKey(field reflect.StructField) (string, bool) {
+	_, isCommand := field.Tag.Lookup("command")
+	_, isPositional := field.Tag.Lookup("positional")
+	key := strings.Split(field.Tag.Get("toml"), ",")[0]
+	if field.PkgPath != "" || isCommand || isPositional || isFieldExcluded(field) {
+		return "", false
+	}
+	if key == "" {
+		key = field.Name
+	}
+	return key, true
+}
+
+// UpdateConfigFile sets the keys of TOML file 'path' to 'values' keeping the rest of the file intact:
+// comments, the order of keys and formatting are preserved. The keys of 'values' are dotted paths
+// like "db.host", nested maps are written as tables. Existing keys are matched case insensitively
+// (as the TOML decoder matches the structure fields). Missing keys are added to the end of their
+// tables, missing tables are created at the end of file. The file is created if it does not exist.
+// The file is written atomically: the new content is written to temporary file which then
+// replaces the original one, so a crash never leaves a half-written config.
+func UpdateConfigFile(path string, values map[string]interface{}) error {
+	return updateConfigFile(path, values, nil)
+}
+
+// tomlSection is the value written as table or array of tables, see setTOMLSection.
+type tomlSection struct {
+	value    reflect.Value
+	old      reflect.Value // the value stored in the file, invalid if it is unknown
+	template string        // the key of template entry of map kept in the file, see ConfigTemplateKey
+}
+
+// updateConfigFile sets the keys of TOML file 'path' to 'values' like UpdateConfigFile does
+// and replaces the tables or the arrays of tables by 'sections' (see setTOMLSection).
+func updateConfigFile(path string, values map[string]interface{}, sections map[string]tomlSection) error {
+	path, err := NormalizePath(path)
+	if err != nil {
+		return err
+	}
+	var data []byte
+	perm := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+		if data, err = ioutil.ReadFile(path); err != nil {
+			return err
+		}
+	}
+
+	flat := map[string]interface{}{}
+	flattenConfigValues(values, "", flat)
+	keys := make([]string, 0, len(flat))
+	for key := range flat {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	text := string(data)
+	for _, key := range keys {
+		value, err := FormatTOMLValue(flat[key])
+		if err != nil {
+			return fmt.Errorf("Can't write key '%v': %v", key, err)
+		}
+		if text, err = setTOMLKey(text, splitTOMLKey(key), value); err != nil {
+			return fmt.Errorf("Can't write key '%v': %v", key, err)
+		}
+	}
+	keys = keys[:0]
+	for key := range sections {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if text, err = setTOMLSection(text, splitTOMLKey(key), sections[key]); err != nil {
+			return fmt.Errorf("Can't write key '%v': %v", key, err)
+		}
+	}
+	return WriteFileAtomic(path, []byte(text), perm)
+}
+
+// WriteFileAtomic writes 'data' to temporary file in the directory of 'path'
+// and then renames it to 'path'.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := ioutil.TempFile(dir, "."+name+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpName, perm)
+	}
+	if err == nil {
+		err = os.Rename(tmpName, path)
+	}
+	if err != nil {
+		os.Remove(tmpName)
+	}
+	return err
+}
+
+func flattenConfigValues(values map[string]interface{}, prefix string, flat map[string]interface{}) {
+	for key, value := range values {
+		if subMap, ok := value.(map[string]interface{}); ok {
+			flattenConfigValues(subMap, prefix+key+".", flat)
+		} else {
+			flat[prefix+key] = value
+		}
+	}
+}
+
+// FormatTOMLValue returns TOML representation of 'value'.
+// Structures and maps are formatted as inline tables, 'time.Duration' as string ("1m30s").
+func FormatTOMLValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", fmt.Errorf("TOML has no null value")
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	case time.Duration:
+		return quoteTOMLString(v.String()), nil
+	case encoding.TextMarshaler:
+		text, err := v.MarshalText()
+		return quoteTOMLString(string(text)), err
+	}
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return "", fmt.Errorf("TOML has no null value")
+		}
+		return FormatTOMLValue(rv.Elem().Interface())
+	case reflect.String:
+		return quoteTOMLString(rv.String()), nil
+	case reflect.Bool:
+		return strconv.FormatBool(rv.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(rv.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		f := rv.Float()
+		switch {
+		case math.IsNaN(f):
+			return "nan", nil
+		case math.IsInf(f, 1):
+			return "inf", nil
+		case math.IsInf(f, -1):
+			return "-inf", nil
+		}
+		s := strconv.FormatFloat(f, 'g', -1, rv.Type().Bits())
+		if !strings.ContainsAny(s, ".e") {
+			s += ".0"
+		}
+		return s, nil
+	case reflect.Slice, reflect.Array:
+		items := make([]string, rv.Len())
+		for i := range items {
+			item, err := FormatTOMLValue(rv.Index(i).Interface())
+			if err != nil {
+				return "", err
+			}
+			items[i] = item
+		}
+		return "[" + strings.Join(items, ", ") + "]", nil
+	case reflect.Map:
+		keys := make([]string, 0, rv.Len())
+		values := map[string]string{}
+		for _, k := range rv.MapKeys() {
+			item, err := FormatTOMLValue(rv.MapIndex(k).Interface())
+			if err != nil {
+				return "", err
+			}
+			key := fmt.Sprint(k.Interface())
+			keys = append(keys, key)
+			values[key] = item
+		}
+		sort.Strings(keys)
+		items := make([]string, len(keys))
+		for i, key := range keys {
+			items[i] = quoteTOMLKey(key) + " = " + values[key]
+		}
+		return "{" + strings.Join(items, ", ") + "}", nil
+	case reflect.Struct:
+		var items []string
+		for i := 0; i < rv.NumField(); i++ {
+			key, ok := getTOMLFieldKey(rv.Type().Field(i))
+			if !ok || (rv.Field(i).Kind() == reflect.Ptr && rv.Field(i).IsNil()) {
+				continue
+			}
+			item, err := FormatTOMLValue(rv.Field(i).Interface())
+			if err != nil {
+				return "", err
+			}
+			items = append(items, quoteTOMLKey(key)+" = "+item)
+		}
+		return "{" + strings.Join(items, ", ") + "}", nil
+	}
+	return "", fmt.Errorf("type %T is not supported", value)
+}
+
+func quoteTOMLString(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			sb.WriteString(`\"`)
+		case '\\':
+			sb.WriteString(`\\`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		default:
+			if r < 0x20 || r == 0x7F {
+				fmt.Fprintf(&sb, `\u%04X`, r)
+			} else {
+				sb.WriteRune(r)
+			}
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+func quoteTOMLKey(key string) string {
+	for _, r := range key {
+		if !(r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return quoteTOMLString(key)
+		}
+	}
+	if key == "" {
+		return `""`
+	}
+	return key
+}
+
+// splitTOMLKey splits dotted key path, the parts may be quoted: a."b.c".d
+func splitTOMLKey(key string) []string {
+	var parts []string
+	var part strings.Builder
+	quote := byte(0)
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			if c == '\\' && quote == '"' && i+1 < len(key) {
+				i++
+				c = key[i]
+			}
+			part.WriteByte(c)
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '.':
+			parts = append(parts, strings.TrimSpace(part.String()))
+			part.Reset()
+		default:
+			part.WriteByte(c)
+		}
+	}
+	return append(parts, strings.TrimSpace(part.String()))
+}
+
+// tomlKeyValue is the location of 'key = value' pair in TOML text.
+type tomlKeyValue struct {
+	table      []string // the table containing the pair
+	key        []string // the key relative to the table (dotted keys have several parts)
+	valueStart int      // byte offsets of the value
+	valueEnd   int
+	lineEnd    int  // offset of the end of line where the value ends (after '\n')
+	inArray    bool // the pair of '[[array]]' table, it is updated only by updateTOMLArrayTable
+}
+
+// tomlTable is the location of '[table]' header in TOML text.
+type tomlTable struct {
+	path    []string
+	isArray bool // '[[array]]' table
+	start   int  // offset of the header
+	end     int  // offset of the end of the last line of the table contents
+}
+
+// parseTOMLLayout finds the locations of tables and key/value pairs in TOML text.
+// The root table is the first one, it has empty path.
+func parseTOMLLayout(text string) ([]*tomlTable, []*tomlKeyValue, error) {
+	tables := []*tomlTable{{}}
+	var pairs []*tomlKeyValue
+	current := tables[0]
+	pos := 0
+	for pos < len(text) {
+		lineStart := pos
+		pos = skipTOMLSpaces(text, pos)
+		if pos >= len(text) {
+			break
+		}
+		switch text[pos] {
+		case '\n':
+			pos++
+			continue
+		case '#':
+			pos = skipTOMLLine(text, pos)
+			continue
+		case '[':
+			isArray := strings.HasPrefix(text[pos:], "[[")
+			closing := "]"
+			if isArray {
+				closing = "]]"
+			}
+			end := strings.Index(text[pos:], closing)
+			if end < 0 {
+				return nil, nil, fmt.Errorf("unclosed table header at offset %v", pos)
+			}
+			name := strings.TrimSpace(text[pos+len(closing) : pos+end])
+			current = &tomlTable{path: splitTOMLKey(name), isArray: isArray, start: lineStart}
+			tables = append(tables, current)
+			pos = skipTOMLLine(text, pos+end+len(closing))
+			current.end = pos
+			continue
+		}
+		eq := strings.IndexByte(text[pos:], '=')
+		if eq < 0 || strings.Contains(text[pos:pos+eq], "\n") {
+			return nil, nil, fmt.Errorf("expected 'key = value' at offset %v", pos)
+		}
+		key := splitTOMLKey(text[pos : pos+eq])
+		valueStart := skipTOMLSpaces(text, pos+eq+1)
+		valueEnd, err := scanTOMLValue(text, valueStart)
+		if err != nil {
+			return nil, nil, err
+		}
+		pos = skipTOMLLine(text, valueEnd)
+		pairs = append(pairs, &tomlKeyValue{table: current.path, key: key, valueStart: valueStart,
+			valueEnd: valueEnd, lineEnd: pos, inArray: current.isArray})
+		current.end = pos
+	}
+	return tables, pairs, nil
+}
+
+func skipTOMLSpaces(text string, pos int) int {
+	for pos < len(text) && (text[pos] == ' ' || text[pos] == '\t' || text[pos] == '\r') {
+		pos++
+	}
+	return pos
+}
+
+// skipTOMLLine returns the offset of the next line.
+func skipTOMLLine(text string, pos int) int {
+	if i := strings.IndexByte(text[pos:], '\n'); i >= 0 {
+		return pos + i + 1
+	}
+	return len(text)
+}
+
+// scanTOMLValue returns the offset of the end of value starting at 'pos'.
+func scanTOMLValue(text string, pos int) (int, error) {
+	if pos >= len(text) {
+		return pos, fmt.Errorf("missing value at offset %v", pos)
+	}
+	for _, delim := range [...]string{`"""`, `'''`} {
+		if strings.HasPrefix(text[pos:], delim) {
+			end := pos + len(delim)
+			for {
+				i := strings.Index(text[end:], delim)
+				if i < 0 {
+					return pos, fmt.Errorf("unclosed multi-line string at offset %v", pos)
+				}
+				end += i
+				if delim == `"""` && isEscapedTOMLChar(text, end) {
+					end++
+					continue
+				}
+				end += len(delim)
+				for end < len(text) && text[end] == delim[0] { // up to two quotes are allowed before delimiter
+					end++
+				}
+				return end, nil
+			}
+		}
+	}
+	switch text[pos] {
+	case '"', '\'':
+		for end := pos + 1; end < len(text) && text[end] != '\n'; end++ {
+			if text[end] == text[pos] && (text[pos] == '\'' || !isEscapedTOMLChar(text, end)) {
+				return end + 1, nil
+			}
+		}
+		return pos, fmt.Errorf("unclosed string at offset %v", pos)
+	case '[', '{':
+		depth := 0
+		for end := pos; end < len(text); {
+			switch text[end] {
+			case '[', '{':
+				depth++
+			case ']', '}':
+				depth--
+				if depth == 0 {
+					return end + 1, nil
+				}
+			case '#':
+				end = skipTOMLLine(text, end)
+				continue
+			case '"', '\'':
+				strEnd, err := scanTOMLValue(text, end)
+				if err != nil {
+					return pos, err
+				}
+				end = strEnd
+				continue
+			}
+			end++
+		}
+		return pos, fmt.Errorf("unclosed array or inline table at offset %v", pos)
+	}
+	end := pos
+	for end < len(text) && text[end] != '\n' && text[end] != '#' {
+		end++
+	}
+	return pos + len(strings.TrimRight(text[pos:end], " \t\r")), nil
+}
+
+// isEscapedTOMLChar reports whether the char at 'pos' is preceded by odd number of backslashes.
+func isEscapedTOMLChar(text string, pos int) bool {
+	n := 0
+	for i := pos - 1; i >= 0 && text[i] == '\\'; i-- {
+		n++
+	}
+	return n%2 == 1
+}
+
+func isTOMLKeyEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !strings.EqualFold(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func formatTOMLKey(key []string) string {
+	quoted := make([]string, len(key))
+	for i, part := range key {
+		quoted[i] = quoteTOMLKey(part)
+	}
+	return strings.Join(quoted, ".")
+}
+
+// removeTOMLKey removes the line of 'key = value' pair from TOML text.
+func removeTOMLKey(text string, key []string) (string, error) {
+	_, pairs, err := parseTOMLLayout(text)
+	if err != nil {
+		return text, err
+	}
+	for _, pair := range pairs {
+		if !pair.inArray && isTOMLKeyEqual(append(append([]string{}, pair.table...), pair.key...), key) {
+			lineStart := strings.LastIndexByte(text[:pair.valueStart], '\n') + 1
+			return text[:lineStart] + text[pair.lineEnd:], nil
+		}
+	}
+	return text, fmt.Errorf("key '%v' can't be removed", formatTOMLKey(key))
+}
+
+// setTOMLKey replaces the value of 'key' in TOML text or inserts the key.
+func setTOMLKey(text string, key []string, value string) (string, error) {
+	tables, pairs, err := parseTOMLLayout(text)
+	if err != nil {
+		return text, err
+	}
+	for _, pair := range pairs {
+		if !pair.inArray && isTOMLKeyEqual(append(append([]string{}, pair.table...), pair.key...), key) {
+			return text[:pair.valueStart] + value + text[pair.valueEnd:], nil
+		}
+	}
+
+	// the key is missing: find the table to insert it
+	tablePath := key[:len(key)-1]
+	var target *tomlTable
+	relKey := key[len(key)-1:]
+	for _, table := range tables {
+		if table.path != nil && isTOMLKeyEqual(table.path, tablePath) {
+			if table.isArray {
+				return text, fmt.Errorf("the keys of array of tables '%v' can't be updated", formatTOMLKey(tablePath))
+			}
+			target = table
+		}
+	}
+	if target == nil && len(tablePath) > 0 {
+		// the table may be defined implicitly by dotted keys: 'db.host = ...'
+		for _, pair := range pairs {
+			full := append(append([]string{}, pair.table...), pair.key...)
+			if !pair.inArray && len(pair.key) > 1 && len(full) > len(tablePath) &&
+				isTOMLKeyEqual(full[:len(tablePath)], tablePath) && len(pair.table) <= len(tablePath) {
+				for _, table := range tables {
+					if !table.isArray && isTOMLKeyEqual(table.path, pair.table) {
+						target, relKey = table, key[len(pair.table):]
+					}
+				}
+				break
+			}
+		}
+	}
+	if target == nil && len(tablePath) == 0 {
+		target = tables[0]
+	}
+
+	if target == nil { // new table at the end of file
+		if text != "" && !strings.HasSuffix(text, "\n") {
+			text += "\n"
+		}
+		if text != "" {
+			text += "\n"
+		}
+		return text + "[" + formatTOMLKey(tablePath) + "]\n" + formatTOMLKey(relKey) + " = " + value + "\n", nil
+	}
+	line := formatTOMLKey(relKey) + " = " + value + "\n"
+	insertPos := target.end
+	if target == tables[0] && insertPos == 0 {
+		insertPos = len(text)
+		if len(tables) > 1 { // root table without keys: insert before the first header
+			insertPos = tables[1].start
+			line += "\n"
+		}
+	}
+	if insertPos > 0 && text[insertPos-1] != '\n' {
+		line = "\n" + line
+	}
+	return text[:insertPos] + line + text[insertPos:], nil
+}
+
+// isTOMLSectionValue reports whether the field value is written as table or array of tables:
+// it is a map with string keys or a slice of structures or of such maps.
+func isTOMLSectionValue(v reflect.Value) bool {
+	t := v.Type()
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		for t = t.Elem(); t.Kind() == reflect.Ptr; t = t.Elem() {
+		}
+		if t.Kind() == reflect.Struct {
+			return t != reflect.TypeOf(time.Time{})
+		}
+	}
+	return t.Kind() == reflect.Map && t.Key().Kind() == reflect.String
+}
+
+// setTOMLSection writes the value of 'section' (a map or a slice of structures) as the table or the array
+// of tables 'key' (with its sub-tables) of TOML text. If the stored value is known, the existing tables
+// are updated in place (see updateTOMLSection), otherwise they are replaced (see writeTOMLSection)
+// keeping the tables of template entry as is. The name of table and the keys of structure fields
+// keep the spelling used in the existing tables. The value replaces the value of 'key = value' pair
+// if the text has it. Missing tables are added to the end of text.
+func setTOMLSection(text string, key []string, section tomlSection) (string, error) {
+	value := section.value
+	tables, pairs, err := parseTOMLLayout(text)
+	if err != nil {
+		return text, err
+	}
+	for _, pair := range pairs {
+		if pair.inArray {
+			continue
+		}
+		full := append(append([]string{}, pair.table...), pair.key...)
+		if isTOMLKeyEqual(full, key) {
+			inline, err := FormatTOMLValue(value.Interface())
+			if err != nil {
+				return text, err
+			}
+			return text[:pair.valueStart] + inline + text[pair.valueEnd:], nil
+		}
+		if len(pair.table) < len(key) && len(full) > len(key) && isTOMLKeyEqual(full[:len(key)], key) {
+			return text, fmt.Errorf("the table '%v' is defined by dotted keys", formatTOMLKey(key))
+		}
+	}
+	first, last := -1, -1
+	for i, table := range tables {
+		if len(table.path) < len(key) || !isTOMLKeyEqual(table.path[:len(key)], key) {
+			continue
+		}
+		if first >= 0 && last != i-1 {
+			return text, fmt.Errorf("the tables of '%v' are separated by other tables", formatTOMLKey(key))
+		}
+		if first < 0 {
+			first = i
+			key = table.path[:len(key)]
+		}
+		last = i
+	}
+
+	spelling := map[string]string{} // the keys of replaced tables by their lower case
+	if first >= 0 {
+		for _, pair := range pairs {
+			if pair.valueStart > tables[first].start && pair.valueStart < tables[last].end {
+				for _, k := range pair.key {
+					spelling[strings.ToLower(k)] = k
+				}
+			}
+		}
+		for _, table := range tables[first : last+1] {
+			for _, k := range table.path[len(key):] {
+				spelling[strings.ToLower(k)] = k
+			}
+		}
+	}
+
+	if first >= 0 && section.old.IsValid() {
+		return updateTOMLSection(text, key, section.old, value, spelling)
+	}
+	var sb strings.Builder
+	if first >= 0 && section.template != "" {
+		for _, table := range tables[first : last+1] {
+			if len(table.path) > len(key) && table.path[len(key)] == section.template {
+				if sb.Len() > 0 {
+					sb.WriteString("\n")
+				}
+				sb.WriteString(strings.TrimRight(text[table.start:table.end], "\n") + "\n")
+			}
+		}
+	}
+	if err = writeTOMLSection(&sb, key, value, spelling); err != nil {
+		return text, err
+	}
+	if first >= 0 {
+		return text[:tables[first].start] + sb.String() + text[tables[last].end:], nil
+	}
+	if text != "" && !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	if text != "" && sb.Len() > 0 {
+		text += "\n"
+	}
+	return text + sb.String(), nil
+}
+
+// writeTOMLSection writes the map or the structure 'value' as table 'path',
+// the slice of them as array of tables. 'spelling' maps the lower case keys of structure fields
+// to the keys written.
+func writeTOMLSection(sb *strings.Builder, path []string, value reflect.Value, spelling map[string]string) error {
+	value = reflect.Indirect(value)
+	if value.Kind() != reflect.Slice && value.Kind() != reflect.Array {
+		return writeTOMLTable(sb, path, value, false, spelling)
+	}
+	for i := 0; i < value.Len(); i++ {
+		if err := writeTOMLTable(sb, path, reflect.Indirect(value.Index(i)), true, spelling); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTOMLTable writes the header of table 'path' ('[[path]]' if 'isArray' is set) and the entries
+// of structure or map 'value'. The entries which are structures, maps or slices of structures
+// are written as sub-tables after the keys of the table.
+func writeTOMLTable(sb *strings.Builder, path []string, value reflect.Value, isArray bool,
+	spelling map[string]string) error {
+	keys, values, err := getTOMLTableEntries(value, spelling)
+	if err != nil {
+		return err
+	}
+	var lines strings.Builder
+	var subKeys []string
+	var subValues []reflect.Value
+	for i, v := range values {
+		if isTOMLTableValue(v) {
+			subKeys = append(subKeys, keys[i])
+			subValues = append(subValues, v)
+			continue
+		}
+		item, err := FormatTOMLValue(v.Interface())
+		if err != nil {
+			return err
+		}
+		lines.WriteString(quoteTOMLKey(keys[i]) + " = " + item + "\n")
+	}
+	if isArray || lines.Len() > 0 || len(subKeys) == 0 {
+		if sb.Len() > 0 {
+			sb.WriteString("\n")
+		}
+		header := "[" + formatTOMLKey(path) + "]"
+		if isArray {
+			header = "[" + header + "]"
+		}
+		sb.WriteString(header + "\n" + lines.String())
+	}
+	for i, key := range subKeys {
+		if err := writeTOMLSection(sb, append(append([]string{}, path...), key), subValues[i], spelling); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getTOMLTableEntries returns the keys and the values of structure or map 'value' written as TOML table.
+// The keys of structure fields are spelled by 'spelling' (see writeTOMLSection), the keys of map
+// are sorted. The interfaces are replaced by their values, 'nil' values are skipped (TOML has no null value).
+// Invalid 'value' has no entries.
+func getTOMLTableEntries(value reflect.Value, spelling map[string]string) ([]string, []reflect.Value, error) {
+	var keys []string
+	var values []reflect.Value
+	add := func(key string, v reflect.Value) {
+		for v.Kind() == reflect.Interface && !v.IsNil() {
+			v = v.Elem()
+		}
+		if (v.Kind() != reflect.Ptr && v.Kind() != reflect.Interface) || !v.IsNil() {
+			keys = append(keys, key)
+			values = append(values, v)
+		}
+	}
+	switch value.Kind() {
+	case reflect.Invalid:
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			if key, ok := getTOMLFieldKey(value.Type().Field(i)); ok {
+				if spelled, ok := spelling[strings.ToLower(key)]; ok {
+					key = spelled
+				}
+				add(key, value.Field(i))
+			}
+		}
+	case reflect.Map:
+		mapKeys := value.MapKeys()
+		sort.Slice(mapKeys, func(i, j int) bool { return mapKeys[i].String() < mapKeys[j].String() })
+		for _, k := range mapKeys {
+			add(k.String(), value.MapIndex(k))
+		}
+	default:
+		return nil, nil, fmt.Errorf("type %v can't be written as TOML table", value.Type())
+	}
+	return keys, values, nil
+}
+
+// isTOMLTableValue reports whether the value is written as sub-table or array of tables.
+func isTOMLTableValue(v reflect.Value) bool {
+	return v.IsValid() && (isNestedStruct(reflect.Indirect(v)) || isTOMLSectionValue(v))
+}
+
+// indirectTOMLValue returns the value pointed to by pointers and interfaces.
+func indirectTOMLValue(v reflect.Value) reflect.Value {
+	for (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && !v.IsNil() {
+		v = v.Elem()
+	}
+	return v
+}
+
+// updateTOMLSection changes the tables of TOML text written for 'old' value of key 'path'
+// (a structure, a map or a slice of them) so they hold 'value'. The changed keys are replaced in place,
+// the new keys are added to the end of their tables and the missing keys are removed, so the comments
+// and the order of keys are kept. The tables of new entries are added after the tables of 'path',
+// the tables of removed entries are removed. The arrays of tables are updated element by element.
+func updateTOMLSection(text string, path []string, old, value reflect.Value,
+	spelling map[string]string) (string, error) {
+	old, value = indirectTOMLValue(old), indirectTOMLValue(value)
+	if value.Kind() == reflect.Slice || value.Kind() == reflect.Array {
+		return updateTOMLArray(text, path, old, value, spelling)
+	}
+	oldKeys, oldValues, err := getTOMLTableEntries(old, spelling)
+	if err != nil {
+		return text, err
+	}
+	keys, values, err := getTOMLTableEntries(value, spelling)
+	if err != nil {
+		return text, err
+	}
+	for _, key := range oldKeys {
+		if findTOMLEntry(keys, values, key) == nil {
+			keys = append(keys, key)
+			values = append(values, reflect.Value{}) // removed
+		}
+	}
+	for i, key := range keys {
+		oldValue := reflect.Value{}
+		if v := findTOMLEntry(oldKeys, oldValues, key); v != nil {
+			oldValue = *v
+		}
+		if oldValue.IsValid() && values[i].IsValid() && reflect.DeepEqual(oldValue.Interface(), values[i].Interface()) {
+			continue
+		}
+		if text, err = updateTOMLEntry(text, append(append([]string{}, path...), key), oldValue, values[i],
+			spelling); err != nil {
+			return text, err
+		}
+	}
+	return text, nil
+}
+
+// findTOMLEntry returns the value of 'key' found case insensitively in 'keys' or 'nil'.
+func findTOMLEntry(keys []string, values []reflect.Value, key string) *reflect.Value {
+	for i := range keys {
+		if strings.EqualFold(keys[i], key) {
+			return &values[i]
+		}
+	}
+	return nil
+}
+
+// updateTOMLEntry changes the entry 'key' of TOML table from 'old' value to 'value',
+// the invalid values mean the missing entry, see updateTOMLSection.
+func updateTOMLEntry(text string, key []string, old, value reflect.Value, spelling map[string]string) (string, error) {
+	tables, pairs, err := parseTOMLLayout(text)
+	if err != nil {
+		return text, err
+	}
+	hasPair, hasKeys := false, false
+	for _, pair := range pairs {
+		if pair.inArray {
+			continue
+		}
+		full := append(append([]string{}, pair.table...), pair.key...)
+		hasPair = hasPair || isTOMLKeyEqual(full, key)
+		hasKeys = hasKeys || (len(full) > len(key) && isTOMLKeyEqual(full[:len(key)], key))
+	}
+	for _, table := range tables {
+		hasKeys = hasKeys || (len(table.path) >= len(key) && isTOMLKeyEqual(table.path[:len(key)], key))
+	}
+	isTable := isTOMLTableValue(value) || (!value.IsValid() && isTOMLTableValue(old))
+	switch {
+	case hasPair && !value.IsValid():
+		return removeTOMLKey(text, key)
+	case hasPair || (!isTable && value.IsValid()):
+		item, err := FormatTOMLValue(value.Interface())
+		if err != nil {
+			return text, err
+		}
+		return setTOMLKey(text, key, item)
+	case !isTable:
+		return text, nil // the key is not written in the file
+	case !value.IsValid():
+		return removeTOMLTables(text, key), nil
+	case hasKeys:
+		return updateTOMLSection(text, key, old, value, spelling)
+	}
+	var sb strings.Builder
+	if err = writeTOMLSection(&sb, key, value, spelling); err != nil {
+		return text, err
+	}
+	return insertTOMLTables(text, tables, key[:len(key)-1], sb.String()), nil
+}
+
+// updateTOMLArray changes the array of tables 'path' of TOML text from 'old' slice to 'value' element
+// by element, see updateTOMLSection. The array is replaced if the text does not match 'old' slice.
+func updateTOMLArray(text string, path []string, old, value reflect.Value, spelling map[string]string) (string, error) {
+	oldLen := 0
+	if old.Kind() == reflect.Slice || old.Kind() == reflect.Array {
+		oldLen = old.Len()
+	}
+	tables, _, err := parseTOMLLayout(text)
+	if err != nil {
+		return text, err
+	}
+	if len(getTOMLArrayTables(tables, path)) != oldLen {
+		return setTOMLSection(text, path, tomlSection{value: value})
+	}
+	for i := 0; i < oldLen && i < value.Len(); i++ {
+		oldItem, item := indirectTOMLValue(old.Index(i)), indirectTOMLValue(value.Index(i))
+		if !reflect.DeepEqual(oldItem.Interface(), item.Interface()) {
+			if text, err = updateTOMLArrayTable(text, path, i, oldItem, item, spelling); err != nil {
+				return text, err
+			}
+		}
+	}
+	for i := oldLen - 1; i >= value.Len(); i-- {
+		tables, _, err = parseTOMLLayout(text)
+		if err != nil {
+			return text, err
+		}
+		elements := getTOMLArrayTables(tables, path)
+		text = removeTOMLTableRange(text, tables, elements[i], getTOMLTableGroupEnd(tables, elements[i], path))
+	}
+	if value.Len() <= oldLen {
+		return text, nil
+	}
+	var sb strings.Builder
+	for i := oldLen; i < value.Len(); i++ {
+		if err = writeTOMLTable(&sb, path, indirectTOMLValue(value.Index(i)), true, spelling); err != nil {
+			return text, err
+		}
+	}
+	if tables, _, err = parseTOMLLayout(text); err != nil {
+		return text, err
+	}
+	return insertTOMLTables(text, tables, path, sb.String()), nil
+}
+
+// updateTOMLArrayTable changes the element 'index' of the array of tables 'path' from 'old' to 'value'.
+// The keys are updated in place, the element is rewritten if its sub-tables are changed.
+func updateTOMLArrayTable(text string, path []string, index int, old, value reflect.Value,
+	spelling map[string]string) (string, error) {
+	oldKeys, oldValues, err := getTOMLTableEntries(old, spelling)
+	if err != nil {
+		return text, err
+	}
+	keys, values, err := getTOMLTableEntries(value, spelling)
+	if err != nil {
+		return text, err
+	}
+	rewrite := false
+	for _, key := range oldKeys {
+		if v := findTOMLEntry(keys, values, key); v == nil {
+			keys = append(keys, key)
+			values = append(values, reflect.Value{})
+		}
+	}
+	for i, key := range keys {
+		oldValue := findTOMLEntry(oldKeys, oldValues, key)
+		if oldValue != nil && values[i].IsValid() && reflect.DeepEqual(oldValue.Interface(), values[i].Interface()) {
+			continue
+		}
+		if isTOMLTableValue(values[i]) || (oldValue != nil && isTOMLTableValue(*oldValue)) {
+			rewrite = true
+			break
+		}
+	}
+	tables, pairs, err := parseTOMLLayout(text)
+	if err != nil {
+		return text, err
+	}
+	element := getTOMLArrayTables(tables, path)[index]
+	if rewrite {
+		var sb strings.Builder
+		if err = writeTOMLTable(&sb, path, value, true, spelling); err != nil {
+			return text, err
+		}
+		end := getTOMLTableGroupEnd(tables, element, path)
+		return text[:tables[element].start] + sb.String() + text[tables[end].end:], nil
+	}
+	for i := len(keys) - 1; i >= 0; i-- { // from the end, so the offsets of preceding pairs are valid
+		oldValue := findTOMLEntry(oldKeys, oldValues, keys[i])
+		if oldValue != nil && values[i].IsValid() && reflect.DeepEqual(oldValue.Interface(), values[i].Interface()) {
+			continue
+		}
+		var found *tomlKeyValue
+		for _, pair := range pairs {
+			if pair.valueStart > tables[element].start && pair.valueStart < tables[element].end &&
+				isTOMLKeyEqual(pair.key, []string{keys[i]}) {
+				found = pair
+			}
+		}
+		switch {
+		case found != nil && !values[i].IsValid():
+			lineStart := strings.LastIndexByte(text[:found.valueStart], '\n') + 1
+			text = text[:lineStart] + text[found.lineEnd:]
+		case found != nil:
+			item, err := FormatTOMLValue(values[i].Interface())
+			if err != nil {
+				return text, err
+			}
+			text = text[:found.valueStart] + item + text[found.valueEnd:]
+		case values[i].IsValid():
+			item, err := FormatTOMLValue(values[i].Interface())
+			if err != nil {
+				return text, err
+			}
+			line := quoteTOMLKey(keys[i]) + " = " + item + "\n"
+			end := tables[element].end
+			if end > 0 && text[end-1] != '\n' {
+				line = "\n" + line
+			}
+			text = text[:end] + line + text[end:]
+		}
+	}
+	return text, nil
+}
+
+// getTOMLArrayTables returns the indexes of the tables of array 'path'.
+func getTOMLArrayTables(tables []*tomlTable, path []string) []int {
+	var elements []int
+	for i, table := range tables {
+		if table.isArray && isTOMLKeyEqual(table.path, path) {
+			elements = append(elements, i)
+		}
+	}
+	return elements
+}
+
+// getTOMLTableGroupEnd returns the index of the last sub-table of the table 'first' of 'path'
+// which follow it, or 'first' if it has no sub-tables.
+func getTOMLTableGroupEnd(tables []*tomlTable, first int, path []string) int {
+	last := first
+	for i := first + 1; i < len(tables); i++ {
+		table := tables[i]
+		if len(table.path) <= len(path) || !isTOMLKeyEqual(table.path[:len(path)], path) {
+			break
+		}
+		last = i
+	}
+	return last
+}
+
+// removeTOMLTableRange removes the tables from 'first' to 'last' index with the blank line before them.
+func removeTOMLTableRange(text string, tables []*tomlTable, first, last int) string {
+	start := tables[first].start
+	if strings.HasSuffix(text[:start], "\n\n") {
+		start-- // the blank line before the header
+	}
+	return text[:start] + text[tables[last].end:]
+}
+
+// removeTOMLTables removes the tables of 'key' and their sub-tables from TOML text.
+func removeTOMLTables(text string, key []string) string {
+	tables, _, err := parseTOMLLayout(text)
+	if err != nil {
+		return text
+	}
+	for i := len(tables) - 1; i > 0; i-- { // from the end, so the offsets of preceding tables are valid
+		if path := tables[i].path; len(path) >= len(key) && isTOMLKeyEqual(path[:len(key)], key) {
+			text = removeTOMLTableRange(text, tables, i, i)
+		}
+	}
+	return text
+}
+
+// insertTOMLTables inserts the tables 'section' after the last table of 'parent' or its sub-tables,
+// or to the end of text if there is no such table.
+func insertTOMLTables(text string, tables []*tomlTable, parent []string, section string) string {
+	if section == "" {
+		return text
+	}
+	pos := -1
+	for _, table := range tables[1:] {
+		if len(parent) > 0 && len(table.path) >= len(parent) && isTOMLKeyEqual(table.path[:len(parent)], parent) {
+			pos = table.end
+		}
+	}
+	if pos < 0 {
+		pos = len(text)
+	}
+	before, after := text[:pos], text[pos:]
+	if before != "" && !strings.HasSuffix(before, "\n") {
+		before += "\n"
+	}
+	if before != "" {
+		before += "\n"
+	}
+	if after != "" && !strings.HasPrefix(after, "\n") {
+		section += "\n"
+	}
+	return before + section + after
+}
@@ -0,0 +1,335 @@
+package yagolib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// JSONSchemaDraft is the JSON Schema dialect of schemas generated by GetConfigJSONSchema.
+const JSONSchemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// GetConfigJSONSchema returns the JSON Schema of the config structure pointed to by 'config'
+// (the target of LoadConfig or ParseMapToStruct) which may be passed to json.Marshal.
+// The schema describes:
+//
+//	nested structures     - objects with 'properties'
+//	slices and arrays     - arrays with 'items'
+//	maps                  - objects with 'additionalProperties'
+//	`help` tag            - 'description'
+//	values of structure   - 'default' (with `default` tags applied)
+//	`validate` tag rules  - 'required', 'enum' (oneof), 'minimum'/'maximum', 'minLength'/'maxLength',
+//	                        'minItems'/'maxItems' (min and max) and 'pattern'
+//
+// The property is named by the first alternative name of field (see GetFieldAliases) or by the field name
+// in lower case. The spellings of key accepted by ParseMapToStruct (other case, '-'/'_' separators, aliases)
+// are matched by 'patternProperties' referring to the property schema.
+// The 'required' list contains the property names, so the required keys must be spelled as in the schema.
+// Unknown keys are allowed because they are ignored by default (see ConfigUnknownKeys).
+// 'time.Duration' is either a string like "1m30s" or an integer number of nanoseconds,
+// 'time.Time' is a string of 'date-time' format.
+// The structure types containing themselves (like 'Child *Node' field of 'Node') are described once
+// in '$defs' and referred to by '$ref'.
+func GetConfigJSONSchema(config interface{}) (map[string]interface{}, error) {
+	if config == nil || reflect.TypeOf(config).Kind() != reflect.Ptr ||
+		reflect.TypeOf(config).Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("'config' argument is not a pointer to structure. It has type: %T", config)
+	}
+	defaults := reflect.New(reflect.TypeOf(config).Elem())
+	defaults.Elem().Set(reflect.ValueOf(config).Elem())
+	if err := SetDefaults(defaults.Interface()); err != nil {
+		return nil, err
+	}
+	b := &jsonSchemaBuilder{parents: map[reflect.Type]bool{}}
+	schema := b.getStructJSONSchema(defaults.Elem().Type(), defaults.Elem(), "#")
+	schema["$schema"] = JSONSchemaDraft
+	if len(b.defs) > 0 {
+		schema["$defs"] = b.defs
+	}
+	return schema, nil
+}
+
+// WriteConfigJSONSchema writes the indented JSON Schema of config structure pointed to by 'config',
+// see GetConfigJSONSchema.
+func WriteConfigJSONSchema(w io.Writer, config interface{}) error {
+	schema, err := GetConfigJSONSchema(config)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// jsonSchemaBuilder holds the state of GetConfigJSONSchema.
+type jsonSchemaBuilder struct {
+	parents map[reflect.Type]bool   // the types of enclosing structures
+	defs    map[string]interface{}  // the schemas of recursive types by their names in '$defs'
+	names   map[reflect.Type]string // the names of recursive types in '$defs'
+}
+
+// getStructJSONSchema returns the object schema of 'structType'. 'structValue' holds the default values,
+// it is invalid for the elements of slices and maps. 'pointer' is the JSON pointer of the schema
+// used by the references of 'patternProperties'. The structure nested into itself refers to '$defs'.
+func (b *jsonSchemaBuilder) getStructJSONSchema(structType reflect.Type, structValue reflect.Value,
+	pointer string) map[string]interface{} {
+	if b.parents[structType] {
+		return b.getDefinitionRef(structType)
+	}
+	b.parents[structType] = true
+	defer delete(b.parents, structType)
+	properties := map[string]interface{}{}
+	patterns := map[string]interface{}{}
+	var required []string
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		_, isCommand := field.Tag.Lookup("command")
+		_, isPositional := field.Tag.Lookup("positional")
+		if field.PkgPath != "" || isCommand || isPositional || isFieldExcluded(field) {
+			continue
+		}
+		key := getFieldKey(field)
+		keyPointer := pointer + "/properties/" + escapeJSONPointer(key)
+		var value reflect.Value
+		if structValue.IsValid() {
+			value = structValue.Field(i)
+		}
+		property := b.getFieldJSONSchema(field.Type, value, field.Tag, keyPointer)
+		if help := field.Tag.Get("help"); help != "" {
+			property["description"] = help
+		}
+		properties[key] = property
+		for _, name := range append([]string{field.Name}, GetFieldAliases(field)...) {
+			patterns[getJSONSchemaKeyPattern(name)] = map[string]interface{}{"$ref": keyPointer}
+		}
+		if _, ok := getValidateRule(field.Tag, "required"); ok {
+			required = append(required, key)
+		}
+	}
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(patterns) > 0 {
+		schema["patternProperties"] = patterns
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// getDefinitionRef returns the reference to the schema of recursive structure type in '$defs'.
+// The schema is added to '$defs' on the first reference.
+func (b *jsonSchemaBuilder) getDefinitionRef(structType reflect.Type) map[string]interface{} {
+	name, ok := b.names[structType]
+	if !ok {
+		if b.names == nil {
+			b.names, b.defs = map[reflect.Type]string{}, map[string]interface{}{}
+		}
+		name = structType.Name()
+		for i := 2; b.defs[name] != nil; i++ {
+			name = fmt.Sprintf("%v%v", structType.Name(), i)
+		}
+		b.names[structType] = name
+		b.defs[name] = map[string]interface{}{} // reserves the name
+		parents := b.parents
+		b.parents = map[reflect.Type]bool{}
+		b.defs[name] = b.getStructJSONSchema(structType, reflect.Value{}, "#/$defs/"+escapeJSONPointer(name))
+		b.parents = parents
+	}
+	return map[string]interface{}{"$ref": "#/$defs/" + escapeJSONPointer(name)}
+}
+
+// getFieldJSONSchema returns the schema of value of type 't' with validation rules of 'tag'.
+func (b *jsonSchemaBuilder) getFieldJSONSchema(t reflect.Type, value reflect.Value, tag reflect.StructTag,
+	pointer string) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+		if value.IsValid() {
+			value = value.Elem() // invalid if the pointer is 'nil'
+		}
+	}
+	schema := b.getTypeJSONSchema(t, value, pointer)
+	if value.IsValid() && !isNestedStruct(value) {
+		if def, ok := getJSONSchemaValue(value); ok {
+			schema["default"] = def
+		}
+	}
+	// 'oneof' and 'pattern' rules of slice are applied to every element
+	target := schema
+	elemType := t
+	if (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) && t.Elem().Kind() != reflect.Uint8 {
+		target, _ = schema["items"].(map[string]interface{})
+		for elemType = t.Elem(); elemType.Kind() == reflect.Ptr; elemType = elemType.Elem() {
+		}
+	}
+	if values := getOneOfValues(tag); values != nil && target != nil {
+		enum := make([]interface{}, 0, len(values))
+		for _, s := range values {
+			v := reflect.New(elemType)
+			if TryToConvert(s, v.Interface(), nil) != nil {
+				enum = append(enum, s)
+			} else if ev, ok := getJSONSchemaValue(v.Elem()); ok {
+				enum = append(enum, ev)
+			}
+		}
+		target["enum"] = enum
+	}
+	if pattern, ok := getValidateRule(tag, "pattern"); ok && target != nil {
+		target["pattern"] = pattern
+	}
+	for _, rule := range []string{"min", "max"} {
+		arg, ok := getValidateRule(tag, rule)
+		if !ok {
+			continue
+		}
+		switch t.Kind() {
+		case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+			var limit int
+			if TryToConvert(arg, &limit, nil) != nil {
+				continue
+			}
+			name := map[reflect.Kind]string{reflect.String: "Length", reflect.Map: "Properties"}[t.Kind()]
+			if name == "" {
+				name = "Items"
+			}
+			schema[rule+name] = limit
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+			limit := reflect.New(t)
+			if TryToConvert(arg, limit.Interface(), nil) != nil {
+				continue
+			}
+			name := map[string]string{"min": "minimum", "max": "maximum"}[rule]
+			switch t.Kind() {
+			case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+				schema[name] = limit.Elem().Uint()
+			case reflect.Float32, reflect.Float64:
+				schema[name] = limit.Elem().Float()
+			default:
+				schema[name] = limit.Elem().Int()
+			}
+		}
+	}
+	return schema
+}
+
+// getTypeJSONSchema returns the schema of type 't' without validation rules and default value.
+func (b *jsonSchemaBuilder) getTypeJSONSchema(t reflect.Type, value reflect.Value, pointer string) map[string]interface{} {
+	switch t {
+	case reflect.TypeOf(time.Duration(0)):
+		return map[string]interface{}{"type": []string{"string", "integer"}}
+	case reflect.TypeOf(time.Time{}):
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Struct:
+		return b.getStructJSONSchema(t, value, pointer)
+	case reflect.Slice, reflect.Array:
+		schema := map[string]interface{}{"type": "array",
+			"items": b.getFieldJSONSchema(t.Elem(), reflect.Value{}, "", pointer+"/items")}
+		if t.Kind() == reflect.Array {
+			schema["minItems"] = t.Len()
+			schema["maxItems"] = t.Len()
+		}
+		return schema
+	case reflect.Map:
+		return map[string]interface{}{"type": "object",
+			"additionalProperties": b.getFieldJSONSchema(t.Elem(), reflect.Value{}, "", pointer+"/additionalProperties")}
+	}
+	return map[string]interface{}{} // any value
+}
+
+// getJSONSchemaValue returns the value in the form written to JSON config file.
+// The structures and 'nil' values are not written.
+func getJSONSchemaValue(v reflect.Value) (interface{}, bool) {
+	switch v.Type() {
+	case reflect.TypeOf(time.Duration(0)):
+		return time.Duration(v.Int()).String(), true
+	case reflect.TypeOf(time.Time{}):
+		return v.Interface().(time.Time).Format(time.RFC3339Nano), true
+	}
+	switch v.Kind() {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return v.Interface(), true
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil, false
+		}
+		return getJSONSchemaValue(v.Elem())
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil, false
+		}
+		values := make([]interface{}, v.Len())
+		for i := range values {
+			value, ok := getJSONSchemaValue(v.Index(i))
+			if !ok {
+				return nil, false
+			}
+			values[i] = value
+		}
+		return values, true
+	case reflect.Map:
+		if v.IsNil() || v.Type().Key().Kind() != reflect.String {
+			return nil, false
+		}
+		values := make(map[string]interface{}, v.Len())
+		for _, key := range v.MapKeys() {
+			value, ok := getJSONSchemaValue(v.MapIndex(key))
+			if !ok {
+				return nil, false
+			}
+			values[key.String()] = value
+		}
+		return values, true
+	}
+	return nil, false
+}
+
+// getJSONSchemaKeyPattern returns the regular expression matching the keys similar to 'name'
+// according to IsFieldNameMatch rules. The 'i' flag is not supported by JSON Schema,
+// so both cases of letters are listed: "DB" -> "^[-_ ]*[Dd][-_ ]*[Bb][-_ ]*$".
+func getJSONSchemaKeyPattern(name string) string {
+	var sb strings.Builder
+	sb.WriteString("^[-_ ]*")
+	for _, r := range RemoveCharacters(name, "-_ ") {
+		upper, lower := unicode.ToUpper(r), unicode.ToLower(r)
+		if upper != lower {
+			sb.WriteString("[" + string(upper) + string(lower) + "]")
+		} else {
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+		sb.WriteString("[-_ ]*")
+	}
+	sb.WriteString("$")
+	return sb.String()
+}
+
+// escapeJSONPointer escapes the reference token of JSON pointer (RFC 6901) used in URI fragment.
+func escapeJSONPointer(token string) string {
+	token = strings.Replace(token, "~", "~0", -1)
+	token = strings.Replace(token, "/", "~1", -1)
+	return (&url.URL{Fragment: token}).EscapedFragment()
+}
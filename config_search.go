@@ -0,0 +1,94 @@
+package yagolib
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SearchPolicy defines which of the config files found in the search directories are loaded.
+type SearchPolicy int
+
+const (
+	// SearchMergeAll loads all found files in priority order,
+	// so the settings of higher priority file override the lower priority ones.
+	SearchMergeAll SearchPolicy = iota
+	// SearchFirstFound loads only the highest priority file found.
+	SearchFirstFound
+)
+
+// ConfigSearchDirs is the list of directories where LoadConfig searches 'homeConfigName' file,
+// in priority order from the lowest to the highest. Environment variables ($VAR) and '~'
+// are expanded. If the list is empty, GetDefaultConfigSearchDirs is used.
+var ConfigSearchDirs []string
+
+// ConfigSearchPolicy defines which of the files found in ConfigSearchDirs are loaded.
+var ConfigSearchPolicy = SearchMergeAll
+
+// GetDefaultConfigSearchDirs returns the default config search directories
+// of application 'appName' in priority order from the lowest to the highest:
+// 1. /etc/appName - system-wide config.
+// 2. $XDG_CONFIG_DIRS/appName ('/etc/xdg/appName' if the variable is not set).
+// 3. $XDG_CONFIG_HOME/appName ('~/.config/appName' if the variable is not set).
+// 4. The working directory.
+// See https://specifications.freedesktop.org/basedir-spec/latest/
+func GetDefaultConfigSearchDirs(appName string) []string {
+	dirs := []string{filepath.Join("/etc", appName)}
+	xdgDirs := strings.Split(os.Getenv("XDG_CONFIG_DIRS"), string(os.PathListSeparator))
+	for i := len(xdgDirs) - 1; i >= 0; i-- { // the first dir in XDG_CONFIG_DIRS is the most important
+		if filepath.IsAbs(xdgDirs[i]) {
+			dirs = append(dirs, filepath.Join(xdgDirs[i], appName))
+		}
+	}
+	if len(dirs) == 1 {
+		dirs = append(dirs, filepath.Join("/etc/xdg", appName))
+	}
+	if xdgHome := os.Getenv("XDG_CONFIG_HOME"); filepath.IsAbs(xdgHome) {
+		dirs = append(dirs, filepath.Join(xdgHome, appName))
+	} else {
+		dirs = append(dirs, filepath.Join("~/.config", appName))
+	}
+	return append(dirs, ".")
+}
+
+// getConfigSearchPaths returns all possible paths of 'configName' file in search directories 'dirs'
+// in priority order from the lowest to the highest.
+func getConfigSearchPaths(dirs []string, configName string) []string {
+	if len(dirs) == 0 {
+		dirs = GetDefaultConfigSearchDirs(filepath.Base(os.Args[0]))
+	}
+	var paths []string
+	for _, dir := range dirs {
+		path, err := NormalizePath(filepath.Join(os.ExpandEnv(dir), configName))
+		if err != nil {
+			continue
+		}
+		duplicate := false
+		for _, p := range paths {
+			duplicate = duplicate || (p == path)
+		}
+		if !duplicate {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// FindConfigFiles returns the paths of existing 'configName' files found in ConfigSearchDirs
+// that LoadConfig loads according to ConfigSearchPolicy, in the order of loading.
+func FindConfigFiles(configName string) []string {
+	return findConfigFiles(ConfigSearchDirs, ConfigSearchPolicy, configName)
+}
+
+func findConfigFiles(dirs []string, policy SearchPolicy, configName string) []string {
+	var found []string
+	for _, path := range getConfigSearchPaths(dirs, configName) {
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			found = append(found, path)
+		}
+	}
+	if policy == SearchFirstFound && len(found) > 1 {
+		found = found[len(found)-1:]
+	}
+	return found
+}
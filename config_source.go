@@ -0,0 +1,98 @@
+package yagolib
+
+import (
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ConfigStdinPath is the config file path meaning the standard input:
+//
+//	myapp -c - < config.toml
+//
+// The format of standard input is set by ConfigFormat (WithFormat), TOML by default.
+const ConfigStdinPath = "-"
+
+// configStdinName is the name of standard input shown in messages and provenance.
+const configStdinName = "<stdin>"
+
+// readerSource is the config read from io.Reader. The reader is read once,
+// the data is reused when the config is loaded again (e.g. by ConfigWatcher).
+type readerSource struct {
+	name string
+	r    io.Reader
+	once sync.Once
+	data []byte
+	err  error
+}
+
+func (s *readerSource) read() ([]byte, error) {
+	s.once.Do(func() {
+		s.data, s.err = ioutil.ReadAll(s.r)
+	})
+	return s.data, s.err
+}
+
+// embeddedSource is the set of config files of fs.FS.
+type embeddedSource struct {
+	fsys     fs.FS
+	patterns []string
+}
+
+// WithReader adds the config read from 'r' after the config file given by WithConfigFile.
+// The extension of 'name' selects the decoder (see WithFormat), 'name' is also shown
+// in messages and provenance instead of file path. The relative includes of the config
+// are resolved against the working directory.
+// The reader is read on the first loading, the following loadings use the same data.
+func WithReader(name string, r io.Reader) LoaderOption {
+	return func(ld *Loader) {
+		ld.readers = append(ld.readers, &readerSource{name: name, r: r})
+	}
+}
+
+// WithDefaultsFS adds the config files of 'fsys' (like embed.FS) matching glob 'patterns'
+// as the lowest config file layer, it is overridden by all other config files:
+//
+//	//go:embed defaults/config.toml
+//	var defaults embed.FS
+//
+//	loader := yagolib.NewLoader(yagolib.WithDefaultsFS(defaults, "defaults/*.toml"), ...)
+//
+// The files matching a pattern are loaded in lexical order. Their includes and drop-in directories
+// are searched in 'fsys' too.
+func WithDefaultsFS(fsys fs.FS, patterns ...string) LoaderOption {
+	return func(ld *Loader) {
+		ld.embedded = append(ld.embedded, embeddedSource{fsys: fsys, patterns: patterns})
+	}
+}
+
+// stdinSource is the standard input shared by all Loaders, it may be read only once.
+var stdinSource = &readerSource{name: configStdinName, r: os.Stdin}
+
+// readConfigFile reads config file 'path' of 'fsys', or of OS file system if 'fsys' is 'nil'.
+func readConfigFile(fsys fs.FS, path string) ([]byte, error) {
+	if fsys == nil {
+		return ioutil.ReadFile(path)
+	}
+	return fs.ReadFile(fsys, path)
+}
+
+// isConfigDir reports whether 'path' of 'fsys' (or of OS file system if 'fsys' is 'nil') is a directory.
+func isConfigDir(fsys fs.FS, path string) bool {
+	if fsys == nil {
+		return IsDirExists(path)
+	}
+	info, err := fs.Stat(fsys, path)
+	return err == nil && info.IsDir()
+}
+
+// globConfigFiles returns the files matching 'pattern' in 'fsys' (or in OS file system if 'fsys' is 'nil').
+func globConfigFiles(fsys fs.FS, pattern string) ([]string, error) {
+	if fsys == nil {
+		return filepath.Glob(pattern)
+	}
+	return fs.Glob(fsys, filepath.ToSlash(pattern))
+}
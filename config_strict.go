@@ -0,0 +1,253 @@
+package yagolib
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// UnknownKeysPolicy defines how Loader treats the config keys and environment variables
+// which do not match any config field.
+type UnknownKeysPolicy int
+
+const (
+	// UnknownKeysIgnore silently ignores unknown keys.
+	UnknownKeysIgnore UnknownKeysPolicy = iota
+	// UnknownKeysWarn reports unknown keys to the logger.
+	UnknownKeysWarn
+	// UnknownKeysFail makes unknown keys the errors of ErrConfigUnknownKey kind.
+	UnknownKeysFail
+)
+
+// ConfigUnknownKeys is the default UnknownKeysPolicy of LoadConfig and Loader.
+// Unknown command line flags are always errors.
+var ConfigUnknownKeys = UnknownKeysIgnore
+
+// unknownKey describes the key which does not match any config field.
+type unknownKey struct {
+	key        string // path of the key: "db.prot"
+	suggestion string // the closest known key: "db.port"
+}
+
+func (k unknownKey) message(what string) string {
+	if k.suggestion == "" {
+		return fmt.Sprintf("unknown %v '%v'", what, k.key)
+	}
+	return fmt.Sprintf("unknown %v '%v', did you mean '%v'?", what, k.key, k.suggestion)
+}
+
+// findUnknownKeys returns the keys of config file 'data' which do not match the fields of 'structType'.
+// TOML files are checked by the metadata of decoder, other formats by the keys of decoded map.
+func findUnknownKeys(structType reflect.Type, data []byte, format string, decoder ConfigDecoder) []unknownKey {
+	var unknown []unknownKey
+	switch format {
+	case "toml":
+		md, err := toml.Decode(string(data), reflect.New(structType).Interface())
+		if err != nil {
+			return nil
+		}
+		reported := map[string]bool{}
+		for _, key := range md.Undecoded() {
+			if len(key) > 1 && reported[key[:len(key)-1].String()] {
+				reported[key.String()] = true // the keys of unknown table are not reported
+				continue
+			}
+			reported[key.String()] = true
+			if keyType, ok := getKeyStructType(structType, key[:len(key)-1]); ok {
+				unknown = append(unknown, newUnknownKey(keyType, key))
+			} else {
+				unknown = append(unknown, unknownKey{key: key.String()})
+			}
+		}
+	case "env":
+		known := getEnvVarNames(structType, "")
+		var m map[string]interface{}
+		if decoder(data, &m) != nil {
+			return nil
+		}
+		for key := range m {
+			if !containsString(known, key) {
+				unknown = append(unknown, unknownKey{key: key, suggestion: suggestName(key, known)})
+			}
+		}
+	default:
+		var m map[string]interface{}
+		if decoder(data, &m) != nil {
+			return nil
+		}
+		unknown = findUnknownMapKeys(structType, m, nil)
+	}
+	sort.Slice(unknown, func(i, j int) bool { return unknown[i].key < unknown[j].key })
+	return unknown
+}
+
+// findUnknownMapKeys returns the keys of decoded config map 'm' which do not match the fields of 'structType'.
+// 'prefix' is the path of 'm' keys.
+func findUnknownMapKeys(structType reflect.Type, m map[string]interface{}, prefix []string) []unknownKey {
+	var unknown []unknownKey
+	for key, value := range m {
+		path := append(append([]string{}, prefix...), key)
+		if _, ok := findFieldByKey(structType, key); !ok {
+			unknown = append(unknown, newUnknownKey(structType, path))
+			continue
+		}
+		fieldType, ok := getKeyStructType(structType, []string{key})
+		if !ok {
+			continue
+		}
+		switch v := value.(type) {
+		case map[string]interface{}:
+			unknown = append(unknown, findUnknownMapKeys(fieldType, v, path)...)
+		case []interface{}:
+			for _, item := range v {
+				if itemMap, ok := item.(map[string]interface{}); ok {
+					unknown = append(unknown, findUnknownMapKeys(fieldType, itemMap, path)...)
+				}
+			}
+		}
+	}
+	return unknown
+}
+
+// newUnknownKey makes unknownKey for 'key' path, the suggestion is searched
+// among the fields of 'structType' the last part of key belongs to.
+func newUnknownKey(structType reflect.Type, key []string) unknownKey {
+	k := unknownKey{key: strings.Join(key, ".")}
+	if suggestion := suggestName(key[len(key)-1], getFieldKeys(structType)); suggestion != "" {
+		k.suggestion = strings.Join(append(append([]string{}, key[:len(key)-1]...), suggestion), ".")
+	}
+	return k
+}
+
+// getKeyStructType returns the type of nested structure (or of slice item) referenced by 'keys' path.
+func getKeyStructType(structType reflect.Type, keys []string) (reflect.Type, bool) {
+	for _, name := range keys {
+		field, ok := findFieldByKey(structType, name)
+		if !ok {
+			return nil, false
+		}
+		structType = field.Type
+		for structType.Kind() == reflect.Ptr || structType.Kind() == reflect.Slice {
+			structType = structType.Elem()
+		}
+		if !isNestedStruct(reflect.Zero(structType)) {
+			return nil, false
+		}
+	}
+	return structType, true
+}
+
+// findFieldByKey returns the field of structure matching config key 'key', see IsFieldNameMatch.
+func findFieldByKey(structType reflect.Type, key string) (reflect.StructField, bool) {
+	if structType.Kind() != reflect.Struct {
+		return reflect.StructField{}, false
+	}
+	for i := 0; i < structType.NumField(); i++ {
+		if field := structType.Field(i); field.PkgPath == "" && IsFieldNameMatch(field, key) {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// getFieldKeys returns the config keys of structure fields, see getFieldKey.
+func getFieldKeys(structType reflect.Type) []string {
+	var keys []string
+	for i := 0; i < structType.NumField(); i++ {
+		if field := structType.Field(i); field.PkgPath == "" {
+			keys = append(keys, getFieldKey(field))
+		}
+	}
+	return keys
+}
+
+// getFieldKey returns the config key of structure field: the first alias or the lowercase field name.
+func getFieldKey(field reflect.StructField) string {
+	if aliases := GetFieldAliases(field); len(aliases) > 0 {
+		return aliases[0]
+	}
+	return strings.ToLower(field.Name)
+}
+
+// findUnknownEnvVars returns the environment variables 'environ' ("NAME=value") with 'prefix'
+// which do not match the fields of 'structType'.
+func findUnknownEnvVars(structType reflect.Type, prefix string, environ []string) []unknownKey {
+	if prefix == "" {
+		return nil
+	}
+	known := getEnvVarNames(structType, prefix)
+	var unknown []unknownKey
+	for _, env := range environ {
+		name := strings.SplitN(env, "=", 2)[0]
+		if strings.HasPrefix(name, prefix+"_") && !containsString(known, name) {
+			unknown = append(unknown, unknownKey{key: name, suggestion: suggestName(name, known)})
+		}
+	}
+	sort.Slice(unknown, func(i, j int) bool { return unknown[i].key < unknown[j].key })
+	return unknown
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// suggestName returns the candidate closest to 'name' by edit distance or empty string
+// if none of candidates is close enough. The case of symbols and '-', '_', '.' chars are ignored.
+func suggestName(name string, candidates []string) string {
+	normalize := func(s string) string {
+		return strings.ToLower(RemoveCharacters(s, "-_."))
+	}
+	normName := normalize(name)
+	best, bestDistance := "", (len(normName)+2)/3+1
+	for _, candidate := range candidates {
+		if d := editDistance(normName, normalize(candidate)); d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+	return best
+}
+
+// editDistance returns Damerau-Levenshtein distance (optimal string alignment) between strings:
+// the number of inserted, deleted, replaced and transposed runes.
+func editDistance(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	d := make([][]int, len(s)+1)
+	for i := range d {
+		d[i] = make([]int, len(t)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			d[i][j] = minInt(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && s[i-1] == t[j-2] && s[i-2] == t[j-1] {
+				d[i][j] = minInt(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(s)][len(t)]
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
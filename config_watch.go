@@ -0,0 +1,183 @@
+package yagolib
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// ConfigChangeFunc is called by ConfigWatcher after the config is reloaded.
+// 'oldConfig' and 'newConfig' are pointers to the config structures,
+// 'changedFields' contains the paths of changed fields, like "DB.Host".
+type ConfigChangeFunc func(oldConfig, newConfig interface{}, changedFields []string)
+
+// configReloadDelay is the time to wait for more file events before reloading the config.
+// Editors usually produce several events on save.
+const configReloadDelay = 100 * time.Millisecond
+
+// ConfigWatcher reloads the config when its files are changed.
+type ConfigWatcher struct {
+	loader   *Loader
+	onChange ConfigChangeFunc
+	onError  func(error)
+
+	reloadMu sync.Mutex // serializes reloads
+	mu       sync.Mutex
+	base     reflect.Value // the config structure before loading: defaults set by application
+	current  interface{}
+	timer    *time.Timer
+	closed   bool
+	watch    io.Closer
+}
+
+// WatchConfig loads the config like LoadConfig does and then watches 'homeConfigName' files
+// in all config search directories and 'configPath' file (using inotify on Linux),
+// their drop-in directories (including the ones created later) and the files included by them.
+// When any of them is changed the config is loaded again from all sources into a new structure.
+// The new config must be loaded without errors, including validation done by LoadConfig.
+// Then 'onChange' is called with the old config, the new config and the list of changed fields.
+// If the new config is broken the previous one stays in place and the error is passed to 'onError'
+// (reported as warning to the logger of Loader if 'onError' is 'nil', see WithLogger and 'verbose').
+// The structure pointed to by 'config' is filled by the initial loading only,
+// use Config method to get the current config.
+func WatchConfig(config interface{}, homeConfigName string, configPath string, cmdLine []string, verbose bool,
+	onChange ConfigChangeFunc, onError func(error)) (*ConfigWatcher, error) {
+	return newCompatLoader(homeConfigName, configPath, cmdLine, verbose).Watch(config, onChange, onError)
+}
+
+// Watch loads the config like Load does and then watches its files like WatchConfig does.
+func (ld *Loader) Watch(config interface{}, onChange ConfigChangeFunc, onError func(error)) (*ConfigWatcher, error) {
+	if config == nil || reflect.TypeOf(config).Kind() != reflect.Ptr {
+		return nil, fmt.Errorf("'config' argument is not a pointer to structure. It has type: %T", config)
+	}
+	w := &ConfigWatcher{
+		loader:   ld,
+		onChange: onChange,
+		onError:  onError,
+		base:     copyConfig(config),
+	}
+	l := configLoader{Loader: ld, config: config, prov: Provenance{}}
+	if err := l.load(); err != nil {
+		return nil, err
+	}
+	w.current = config
+
+	var paths []string
+	if ld.homeConfigName != "" {
+		paths = getConfigSearchPaths(ld.searchDirs, ld.homeConfigName) // new file may appear in any search directory
+	}
+	if path, err := NormalizePath(ld.configPath); err == nil && ld.configPath != "" && ld.configPath != ConfigStdinPath {
+		paths = append(paths, path)
+	}
+	for _, path := range paths {
+		paths = append(paths, path+".d") // drop-in directories
+	}
+	paths = append(paths, l.files...) // included files
+	watch, err := startFileWatch(paths, w.scheduleReload)
+	if err != nil {
+		return nil, err
+	}
+	w.watch = watch
+	return w, nil
+}
+
+// Config returns the pointer to the current config structure.
+// The structure must not be modified by the caller.
+func (w *ConfigWatcher) Config() interface{} {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.current
+}
+
+// Close stops watching the config files.
+func (w *ConfigWatcher) Close() error {
+	w.mu.Lock()
+	w.closed = true
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.mu.Unlock()
+	return w.watch.Close()
+}
+
+// scheduleReload is called on file events.
+func (w *ConfigWatcher) scheduleReload() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	if w.timer == nil {
+		w.timer = time.AfterFunc(configReloadDelay, w.reload)
+	} else {
+		w.timer.Reset(configReloadDelay)
+	}
+}
+
+// Reload loads the config again and calls 'onChange' callback if it is changed.
+func (w *ConfigWatcher) Reload() error {
+	w.reloadMu.Lock()
+	defer w.reloadMu.Unlock()
+	newConfig := copyConfig(w.base.Addr().Interface()).Addr().Interface()
+	if err := w.loader.Load(newConfig); err != nil {
+		return fmt.Errorf("Config is not reloaded:\n%w", err)
+	}
+	w.mu.Lock()
+	oldConfig := w.current
+	w.current = newConfig
+	w.mu.Unlock()
+	changed := GetChangedFields(oldConfig, newConfig)
+	if len(changed) > 0 && w.onChange != nil {
+		w.onChange(oldConfig, newConfig, changed)
+	}
+	return nil
+}
+
+func (w *ConfigWatcher) reload() {
+	if err := w.Reload(); err != nil {
+		if w.onError != nil {
+			w.onError(err)
+		} else if w.loader.logger != nil {
+			w.loader.logger.Warnf("%v", err)
+		}
+	}
+}
+
+// copyConfig returns the addressable copy of the structure pointed to by 'config'
+// which shares no slices, maps and pointers with the original.
+func copyConfig(config interface{}) reflect.Value {
+	src := reflect.ValueOf(config).Elem()
+	dst := reflect.New(src.Type()).Elem()
+	deepCopyValue(dst, src)
+	return dst
+}
+
+// GetChangedFields compares two structures (or pointers to structures) of the same type
+// and returns the paths of fields with different values. Nested structures are compared
+// field by field: "DB.Host".
+func GetChangedFields(oldStruct, newStruct interface{}) []string {
+	oldValue := reflect.Indirect(reflect.ValueOf(oldStruct))
+	newValue := reflect.Indirect(reflect.ValueOf(newStruct))
+	if oldValue.Type() != newValue.Type() || oldValue.Kind() != reflect.Struct {
+		return nil
+	}
+	return getChangedFields(oldValue, newValue, "")
+}
+
+func getChangedFields(oldValue, newValue reflect.Value, prefix string) []string {
+	var changed []string
+	for i := 0; i < oldValue.NumField(); i++ {
+		name := prefix + oldValue.Type().Field(i).Name
+		oldField, newField := oldValue.Field(i), newValue.Field(i)
+		if isNestedStruct(oldField) {
+			changed = append(changed, getChangedFields(oldField, newField, name+".")...)
+		} else if !oldField.CanInterface() {
+			continue
+		} else if !reflect.DeepEqual(oldField.Interface(), newField.Interface()) {
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
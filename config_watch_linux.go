@@ -0,0 +1,84 @@
+//go:build linux
+// +build linux
+
+package yagolib
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// startFileWatch calls 'notify' when any of files 'paths' is created, written, moved or deleted.
+// The parent directories are watched, so the files replaced by editors are tracked too.
+// If the path is a directory, any change of files in it is reported, the directory created
+// after the start of watching is watched since it appears.
+func startFileWatch(paths []string, notify func()) (io.Closer, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("inotify init: %v", err)
+	}
+	const mask = syscall.IN_CLOSE_WRITE | syscall.IN_CREATE | syscall.IN_DELETE |
+		syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO
+	files := map[string]bool{}
+	dirs := map[int32]string{}
+	wholeDirs := map[int32]bool{}
+	watchWholeDir := func(path string) {
+		if wd, err := syscall.InotifyAddWatch(fd, path, mask); err == nil {
+			dirs[int32(wd)] = path
+			wholeDirs[int32(wd)] = true
+		}
+	}
+	for _, path := range paths {
+		files[path] = true
+		if IsDirExists(path) {
+			watchWholeDir(path)
+		}
+		dir := filepath.Dir(path)
+		if wd, err := syscall.InotifyAddWatch(fd, dir, mask); err == nil {
+			dirs[int32(wd)] = dir
+		}
+	}
+	if len(dirs) == 0 {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("No config directories to watch: %v", strings.Join(paths, ", "))
+	}
+
+	// non-blocking descriptor is handled by runtime poller, so Close interrupts Read
+	file := os.NewFile(uintptr(fd), "inotify")
+	go func() {
+		buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+		for {
+			n, err := file.Read(buf)
+			if err != nil {
+				return
+			}
+			changed := false
+			for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+				event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+				nameStart := offset + syscall.SizeofInotifyEvent
+				name := strings.TrimRight(string(buf[nameStart:nameStart+int(event.Len)]), "\x00")
+				path := filepath.Join(dirs[event.Wd], name)
+				if wholeDirs[event.Wd] || files[path] {
+					changed = true
+				}
+				if event.Mask&syscall.IN_IGNORED != 0 { // the watched directory is deleted
+					delete(dirs, event.Wd)
+					delete(wholeDirs, event.Wd)
+				} else if !wholeDirs[event.Wd] && files[path] && event.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 &&
+					IsDirExists(path) {
+					watchWholeDir(path) // drop-in directory is created
+				}
+				offset = nameStart + int(event.Len)
+			}
+			if changed {
+				notify()
+			}
+		}
+	}()
+	return file, nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package yagolib
+
+import (
+	"errors"
+	"io"
+)
+
+// startFileWatch is implemented on Linux only.
+func startFileWatch(paths []string, notify func()) (io.Closer, error) {
+	return nil, errors.New("Watching config files is not supported on this platform")
+}
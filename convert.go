@@ -7,6 +7,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/BurntSushi/toml"
 )
 
 // TryToConvert tries to convert 'src' of arbitrary type to target variable
@@ -18,12 +20,29 @@ import (
 // set 'param' to time layout:
 // var t time.Time
 // yagolib.TryToConvert("2019-10-27T18:42:09+03:00", &t, time.RFC3339)
+// 'time.Duration' is converted from strings like "1m30s" or from integer number of nanoseconds.
+// Slices are converted from slices/arrays element by element, from comma-separated strings
+// or from TOML arrays like `["a", "b"]`:
+// var s []int
+// yagolib.TryToConvert("1, 2, 0x3", &s, nil)
+// Maps are converted from maps key by key and value by value or from comma-separated 'key=value' pairs:
+// var m map[string]int
+// yagolib.TryToConvert("a=1, b=2", &m, nil)
+// Structures are converted from maps by ParseMapToStruct rules (without defaults and validation),
+// so slices and maps of structures are converted from slices and maps of maps.
+// For pointer target the new value is allocated.
 func TryToConvert(src, dstPtr, param interface{}) error {
 	if reflect.TypeOf(dstPtr).Kind() == reflect.Ptr {
 		dstVal := reflect.ValueOf(dstPtr).Elem()
 		srcStrOrig := fmt.Sprint(src)
 		srcStr := strings.Trim(srcStrOrig, ` "'`)
 		var err error
+		if dstVal.Type() == reflect.TypeOf(time.Duration(0)) {
+			if d, e := time.ParseDuration(srcStr); e == nil {
+				dstVal.SetInt(int64(d))
+				return nil
+			} // else integer number of nanoseconds
+		}
 		switch dstVal.Kind() {
 		case reflect.Bool:
 			falsesTrues := [...]string{
@@ -79,8 +98,75 @@ func TryToConvert(src, dstPtr, param interface{}) error {
 			}
 		case reflect.String:
 			dstVal.SetString(srcStrOrig)
+		case reflect.Slice:
+			var items []interface{}
+			if srcVal := reflect.ValueOf(src); srcVal.Kind() == reflect.Slice || srcVal.Kind() == reflect.Array {
+				for i := 0; i < srcVal.Len(); i++ {
+					items = append(items, srcVal.Index(i).Interface())
+				}
+			} else if strings.HasPrefix(srcStr, "[") && strings.HasSuffix(srcStr, "]") {
+				var m map[string]interface{}
+				if _, e := toml.Decode("items = "+srcStr, &m); e != nil {
+					err = fmt.Errorf("invalid array %s: %v", srcStr, e)
+					break
+				}
+				items, _ = m["items"].([]interface{})
+			} else if srcStr != "" {
+				for _, item := range strings.Split(srcStr, ",") {
+					items = append(items, strings.Trim(item, ` "'`))
+				}
+			}
+			slice := reflect.MakeSlice(dstVal.Type(), len(items), len(items))
+			for i, item := range items {
+				if err = TryToConvert(item, slice.Index(i).Addr().Interface(), param); err != nil {
+					return err
+				}
+			}
+			dstVal.Set(slice)
+		case reflect.Map:
+			var keys, values []interface{}
+			if srcVal := reflect.ValueOf(src); srcVal.Kind() == reflect.Map {
+				iter := srcVal.MapRange()
+				for iter.Next() {
+					keys = append(keys, iter.Key().Interface())
+					values = append(values, iter.Value().Interface())
+				}
+			} else if srcStr != "" {
+				for _, item := range strings.Split(srcStr, ",") {
+					kv := strings.SplitN(item, "=", 2)
+					if len(kv) != 2 {
+						return fmt.Errorf("Can't convert type '%v' to '%v': 'key=value' expected, found '%s'",
+							reflect.TypeOf(src), dstVal.Type(), strings.TrimSpace(item))
+					}
+					keys = append(keys, strings.Trim(kv[0], ` "'`))
+					values = append(values, strings.Trim(kv[1], ` "'`))
+				}
+			}
+			m := reflect.MakeMapWithSize(dstVal.Type(), len(keys))
+			for i := range keys {
+				key := reflect.New(dstVal.Type().Key())
+				if err = TryToConvert(keys[i], key.Interface(), param); err != nil {
+					return err
+				}
+				value := reflect.New(dstVal.Type().Elem())
+				if err = TryToConvert(values[i], value.Interface(), param); err != nil {
+					return err
+				}
+				m.SetMapIndex(key.Elem(), value.Elem())
+			}
+			dstVal.Set(m)
+		case reflect.Ptr:
+			elem := reflect.New(dstVal.Type().Elem())
+			if err = TryToConvert(src, elem.Interface(), param); err != nil {
+				return err
+			}
+			dstVal.Set(elem)
 		default:
-			if dstVal.Type().String() == "time.Time" {
+			if srcMap, ok := src.(map[string]interface{}); ok && isNestedStruct(dstVal) {
+				_, err = parseMapToStruct(srcMap, dstPtr)
+			} else if t, ok := src.(time.Time); ok && dstVal.Type() == reflect.TypeOf(t) {
+				dstVal.Set(reflect.ValueOf(t))
+			} else if dstVal.Type().String() == "time.Time" {
 				var t time.Time
 				var e error
 				ok := false
@@ -137,13 +223,45 @@ func TryToConvert(src, dstPtr, param interface{}) error {
 //	  Value1   int `intVal`	// tag `intVal` is alternative name
 //	  FloatVal float64
 // }
+// Conventional tags like `toml:"int_val"`, `json:"..."`, `yaml:"..."` define alternative names too,
+// the fields tagged `toml:"-"` (or `json:"-"`, `yaml:"-"`) are never set.
+// Nested structures are filled from nested maps (tables of config file), the slices and maps
+// of structures are filled from slices and maps of nested maps. 'nil' values are skipped.
 // var ts testStruct
 // m := map[string]interface{}{"int_val": 2019, "float_val": 20.19}
 // yagolib.ParseMapToStruct(m, &ts)
 // The 'ts' struct now has values: {2019, 20.19}.
 // Attention! The structure fields must be exported (the first char of name must be capitalized).
+// The maps of structures are filled from the tables of nested maps ([device.camera]), new keys are added
+// to existing map. The slices of structures are filled from the lists of maps ([[server]]).
+// The template entry of the map field tagged `template:""` (like [device.default]) is merged into other entries
+// of the map before mapping and removed from the map, see ConfigTemplateKey ('srcMap' is not modified).
+// The default values of fields are set by SetDefaults before mapping, the entries of maps and slices
+// get the defaults of the fields which keys are missing in the entry (explicit zero values are kept).
+// The structure is validated by ValidateStruct after mapping, the violations are returned as error.
 // The function returns the number of successfully mapped keys and error.
 func ParseMapToStruct(srcMap map[string]interface{}, dstPtr interface{}) (int, error) {
+	if err := SetDefaults(dstPtr); err != nil {
+		return 0, err
+	}
+	if structType := reflect.TypeOf(dstPtr).Elem(); hasMapTemplates(structType, map[reflect.Type]bool{}) {
+		srcMap = copyConfigMap(srcMap)
+		applyMapTemplates(structType, srcMap, "", map[string]map[string]interface{}{})
+	}
+	fieldsCnt, err := parseMapToStruct(srcMap, dstPtr)
+	if err != nil {
+		return fieldsCnt, err
+	}
+	var errs ConfigError
+	setEntryDefaults(reflect.ValueOf(dstPtr).Elem(), srcMap, false, "", &errs)
+	if err = errs.err(); err != nil {
+		return fieldsCnt, err
+	}
+	return fieldsCnt, ValidateStruct(dstPtr)
+}
+
+// parseMapToStruct maps 'srcMap' to the structure without validation.
+func parseMapToStruct(srcMap map[string]interface{}, dstPtr interface{}) (int, error) {
 	var errMsg string
 	fieldsCnt := 0
 	if reflect.TypeOf(dstPtr).Kind() == reflect.Ptr {
@@ -153,14 +271,34 @@ func ParseMapToStruct(srcMap map[string]interface{}, dstPtr interface{}) (int, e
 			for i := 0; i < structType.NumField(); i++ { // iterate through the structure fields
 				field := structType.Field(i)
 				fieldValue := structValue.Field(i)
-				fieldNormName := RemoveCharacters(field.Name, "-_")
-				fieldNormTag := RemoveCharacters(string(field.Tag), "-_ ")
 				for srcKey, srcValue := range srcMap { // search the key of the map that matches structure field
-					itemNormName := RemoveCharacters(srcKey, "-_ ")
-					if strings.EqualFold(itemNormName, fieldNormName) || strings.EqualFold(itemNormName, fieldNormTag) {
+					if srcValue != nil && IsFieldNameMatch(field, srcKey) { // 'nil' (JSON/YAML null) keeps the value
 						if fieldValue.IsValid() {
 							if fieldValue.CanSet() {
-								if err := TryToConvert(srcValue, fieldValue.Addr().Interface(), nil); err == nil {
+								subMap, isMap := srcValue.(map[string]interface{})
+								if isMap && fieldValue.Kind() == reflect.Ptr && isNestedStruct(reflect.Zero(fieldValue.Type().Elem())) {
+									if fieldValue.IsNil() {
+										fieldValue.Set(reflect.New(fieldValue.Type().Elem()))
+									}
+									fieldValue = fieldValue.Elem()
+								}
+								if isMap && isNestedStruct(fieldValue) {
+									n, err := parseMapToStruct(subMap, fieldValue.Addr().Interface())
+									fieldsCnt += n
+									if err != nil {
+										errMsg += prefixErrorLines(err.Error(), field.Name+".") + "\n"
+									}
+								} else if isMap && fieldValue.Kind() == reflect.Map && !fieldValue.IsNil() {
+									m := reflect.New(fieldValue.Type()) // the entries are added to existing map
+									if err := TryToConvert(srcValue, m.Interface(), nil); err == nil {
+										for _, key := range m.Elem().MapKeys() {
+											fieldValue.SetMapIndex(key, m.Elem().MapIndex(key))
+										}
+										fieldsCnt++
+									} else {
+										errMsg += fmt.Sprintf("Can't set field '%v': %v\n", field.Name, err.Error())
+									}
+								} else if err := TryToConvert(srcValue, fieldValue.Addr().Interface(), nil); err == nil {
 									fieldsCnt++
 								} else {
 									errMsg += fmt.Sprintf("Can't set field '%v': %v\n", field.Name, err.Error())
@@ -189,3 +327,66 @@ func ParseMapToStruct(srcMap map[string]interface{}, dstPtr interface{}) (int, e
 	}
 	return fieldsCnt, errors.New(strings.TrimRight(errMsg, "\r\n "))
 }
+
+// IsFieldNameMatch reports whether the map key 'name' refers to structure 'field'
+// according to ParseMapToStruct rules: the case of symbols and '-'/'_' chars are ignored,
+// the alternative names from the field tag are taken into account.
+// The fields with `toml:"-"`, `json:"-"` or `yaml:"-"` tag match no name.
+func IsFieldNameMatch(field reflect.StructField, name string) bool {
+	if isFieldExcluded(field) {
+		return false
+	}
+	normName := RemoveCharacters(name, "-_ ")
+	if strings.EqualFold(normName, RemoveCharacters(field.Name, "-_")) {
+		return true
+	}
+	for _, alias := range GetFieldAliases(field) {
+		if strings.EqualFold(normName, RemoveCharacters(alias, "-_ ")) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetFieldAliases returns alternative names of structure 'field' defined by its tag.
+// The tag may be either the bare name (`intVal`) or conventional key:"value" tag
+// where the names are taken from 'toml', 'json' and 'yaml' keys.
+func GetFieldAliases(field reflect.StructField) []string {
+	tag := string(field.Tag)
+	if tag == "" {
+		return nil
+	}
+	if !strings.Contains(tag, `:"`) {
+		return []string{tag}
+	}
+	var aliases []string
+	for _, key := range [...]string{"toml", "json", "yaml"} {
+		if name := strings.Split(field.Tag.Get(key), ",")[0]; name != "" && name != "-" {
+			aliases = append(aliases, name)
+		}
+	}
+	return aliases
+}
+
+// isFieldExcluded reports whether the structure field is excluded from mapping
+// by `toml:"-"`, `json:"-"` or `yaml:"-"` tag.
+func isFieldExcluded(field reflect.StructField) bool {
+	for _, key := range [...]string{"toml", "json", "yaml"} {
+		if strings.Split(field.Tag.Get(key), ",")[0] == "-" {
+			return true
+		}
+	}
+	return false
+}
+
+// isNestedStruct reports whether the value is a structure which should be filled
+// from a nested map rather than converted by TryToConvert.
+func isNestedStruct(v reflect.Value) bool {
+	return v.Kind() == reflect.Struct && v.Type() != reflect.TypeOf(time.Time{})
+}
+
+// prefixErrorLines adds 'prefix' to the field names of multi-line error message
+// returned by ParseMapToStruct for nested structure.
+func prefixErrorLines(msg, prefix string) string {
+	return strings.Replace(msg, "field '", "field '"+prefix, -1)
+}
@@ -0,0 +1,422 @@
+package yagolib
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// SetDefaults sets the fields of structure pointed to by 'structPtr' to the values
+// of their `default:"..."` tags. The values are converted by TryToConvert:
+//
+//	type Config struct {
+//		Port    int           `default:"8080"`
+//		Timeout time.Duration `default:"5s"`
+//		Tags    []string      `default:"a,b,c"` // comma-separated items of slice
+//		Level   *int          `default:"3"`     // pointer field is allocated
+//	}
+//
+// Only the fields having zero value are set, so the defaults pre-filled in code are kept.
+// Nested structures are processed recursively, 'nil' pointer to structure is allocated
+// if the structure has fields with defaults.
+// LoadConfig also sets the defaults of the fields missing in the entries of maps and slices of structures.
+func SetDefaults(structPtr interface{}) error {
+	if structPtr == nil || reflect.TypeOf(structPtr).Kind() != reflect.Ptr ||
+		reflect.TypeOf(structPtr).Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("'structPtr' must be pointer to structure")
+	}
+	var errs ConfigError
+	setDefaults(reflect.ValueOf(structPtr).Elem(), "", map[reflect.Type]bool{}, &errs)
+	return errs.err()
+}
+
+// setDefaults sets defaults of structure, 'parents' are the types of enclosing structures
+// which are never allocated to avoid infinite recursion.
+func setDefaults(structValue reflect.Value, prefix string, parents map[reflect.Type]bool, errs *ConfigError) {
+	structType := structValue.Type()
+	parents[structType] = true
+	defer delete(parents, structType)
+	for i := 0; i < structType.NumField(); i++ {
+		if fieldValue := structValue.Field(i); fieldValue.CanSet() {
+			setFieldDefaults(structType.Field(i), fieldValue, prefix+structType.Field(i).Name, parents, errs)
+		}
+	}
+}
+
+// setFieldDefaults sets the field to the value of its `default` tag or sets the defaults
+// of nested structure, see setDefaults.
+func setFieldDefaults(field reflect.StructField, fieldValue reflect.Value, path string, parents map[reflect.Type]bool,
+	errs *ConfigError) {
+	if def, ok := field.Tag.Lookup("default"); ok {
+		if fieldValue.IsZero() {
+			if err := TryToConvert(def, fieldValue.Addr().Interface(), nil); err != nil {
+				errs.Entries = append(errs.Entries, &ConfigErrorEntry{Kind: ErrConfigValue, Source: SourceDefault,
+					Field: path, Name: "default", Value: def, Err: err})
+			}
+		}
+		return
+	}
+	if isNestedStruct(fieldValue) {
+		setDefaults(fieldValue, path+".", parents, errs)
+	} else if fieldValue.Kind() == reflect.Ptr && isNestedStruct(reflect.Zero(fieldValue.Type().Elem())) {
+		if fieldValue.IsNil() {
+			if parents[fieldValue.Type().Elem()] || !hasDefaults(fieldValue.Type().Elem(), map[reflect.Type]bool{}) {
+				return
+			}
+			fieldValue.Set(reflect.New(fieldValue.Type().Elem()))
+		}
+		setDefaults(fieldValue.Elem(), path+".", parents, errs)
+	}
+}
+
+// hasDefaults reports whether the structure type has fields with `default` tag.
+// 'visited' prevents infinite recursion for self-referencing types.
+func hasDefaults(structType reflect.Type, visited map[reflect.Type]bool) bool {
+	if visited[structType] {
+		return false
+	}
+	visited[structType] = true
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		if _, ok := field.Tag.Lookup("default"); ok {
+			return true
+		}
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if isNestedStruct(reflect.Zero(fieldType)) && hasDefaults(fieldType, visited) {
+			return true
+		}
+	}
+	return false
+}
+
+// ConfigTemplateKey is the key of the template entry of map of structures tagged `template:""`.
+// The map field tagged `template:"name"` uses the entry 'name' as the template:
+//
+//	type Config struct {
+//		Device map[string]DeviceConfig `template:""`
+//	}
+//
+//	[device.default]
+//	timeout = "5s"
+//
+//	[device.camera]
+//	address = "10.0.0.2"
+//
+// The keys of the template are merged into every other entry of the map before the entries are converted
+// to structures, the keys of the entry override them. The template entry itself is removed from the map.
+// The template of config file applies to the entries of this file and of the files loaded after it.
+// The maps without `template` tag have no template, so their entries named 'default' are regular ones.
+var ConfigTemplateKey = "default"
+
+// hasEntryDefaults reports whether the structure type or its nested structures have the maps
+// or the slices of structures with `default` tags. 'visited' prevents infinite recursion.
+func hasEntryDefaults(structType reflect.Type, visited map[reflect.Type]bool) bool {
+	if visited[structType] {
+		return false
+	}
+	visited[structType] = true
+	for i := 0; i < structType.NumField(); i++ {
+		fieldType := structType.Field(i).Type
+		for fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if entryType, ok := getEntryStructType(fieldType); ok {
+			if hasDefaults(entryType, map[reflect.Type]bool{}) || hasEntryDefaults(entryType, visited) {
+				return true
+			}
+		} else if isNestedStruct(reflect.Zero(fieldType)) && hasEntryDefaults(fieldType, visited) {
+			return true
+		}
+	}
+	return false
+}
+
+// setEntryDefaults sets the defaults of the entries of maps and slices of structures decoded from 'm'
+// into 'structValue': the fields of entry which keys are missing in the decoded entry get the values
+// of their `default` tags. The decoded entries are new structures, so the defaults are applied
+// as if they were set before the keys of entry, and the explicit zero values of entry are kept.
+// 'isEntry' reports that 'structValue' is such entry or its nested structure.
+func setEntryDefaults(structValue reflect.Value, m map[string]interface{}, isEntry bool, prefix string,
+	errs *ConfigError) {
+	structType := structValue.Type()
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		fieldValue := structValue.Field(i)
+		if !fieldValue.CanSet() || isFieldExcluded(field) {
+			continue
+		}
+		path := prefix + field.Name
+		value, found := getDecodedFieldValue(field, m)
+		if !found {
+			if isEntry {
+				setFieldDefaults(field, fieldValue, path, map[reflect.Type]bool{structType: true}, errs)
+			}
+			continue
+		}
+		if _, ok := field.Tag.Lookup("default"); ok {
+			continue
+		}
+		if fieldValue.Kind() == reflect.Ptr && !fieldValue.IsNil() {
+			fieldValue = fieldValue.Elem()
+		}
+		subMap, isMap := value.(map[string]interface{})
+		switch {
+		case isMap && isNestedStruct(fieldValue):
+			setEntryDefaults(fieldValue, subMap, isEntry, path+".", errs)
+		case fieldValue.Kind() == reflect.Slice || fieldValue.Kind() == reflect.Array:
+			items := reflect.ValueOf(value)
+			if items.Kind() != reflect.Slice && items.Kind() != reflect.Array {
+				continue
+			}
+			for j := 0; j < items.Len() && j < fieldValue.Len(); j++ {
+				if item, ok := items.Index(j).Interface().(map[string]interface{}); ok {
+					setEntryValueDefaults(fieldValue.Index(j), item, fmt.Sprintf("%v[%v]", path, j), errs)
+				}
+			}
+		case isMap && fieldValue.Kind() == reflect.Map && !fieldValue.IsNil():
+			for key, entryValue := range subMap {
+				entryMap, ok := entryValue.(map[string]interface{})
+				mapKey := reflect.New(fieldValue.Type().Key())
+				if !ok || TryToConvert(key, mapKey.Interface(), nil) != nil {
+					continue
+				}
+				if stored := fieldValue.MapIndex(mapKey.Elem()); stored.IsValid() {
+					entry := reflect.New(fieldValue.Type().Elem()).Elem()
+					entry.Set(stored)
+					setEntryValueDefaults(entry, entryMap, fmt.Sprintf("%v[%v]", path, key), errs)
+					fieldValue.SetMapIndex(mapKey.Elem(), entry)
+				}
+			}
+		}
+	}
+}
+
+// setEntryValueDefaults sets the defaults of 'entry' of slice or map decoded from 'm'
+// if it is a structure or a pointer to structure, see setEntryDefaults.
+func setEntryValueDefaults(entry reflect.Value, m map[string]interface{}, path string, errs *ConfigError) {
+	if structValue := reflect.Indirect(entry); isNestedStruct(structValue) {
+		setEntryDefaults(structValue, m, true, path+".", errs)
+	}
+}
+
+// getDecodedFieldValue returns the value of decoded map 'm' stored to structure 'field'.
+func getDecodedFieldValue(field reflect.StructField, m map[string]interface{}) (interface{}, bool) {
+	for key, value := range m {
+		if value != nil && IsFieldNameMatch(field, key) {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+// getEntryDefaultsDecoder returns the decoder setting the defaults of the entries of maps and slices
+// of structures decoded by 'decoder', see setEntryDefaults.
+func getEntryDefaultsDecoder(decoder ConfigDecoder) ConfigDecoder {
+	return func(data []byte, v interface{}) error {
+		if err := decoder(data, v); err != nil {
+			return err
+		}
+		structValue := reflect.ValueOf(v)
+		if structValue.Kind() != reflect.Ptr || !isNestedStruct(structValue.Elem()) {
+			return nil
+		}
+		var m map[string]interface{}
+		if err := decoder(data, &m); err != nil {
+			return err
+		}
+		var errs ConfigError
+		setEntryDefaults(structValue.Elem(), m, false, "", &errs)
+		return errs.err()
+	}
+}
+
+// getMapTemplateKey returns the key of the template entry of map 'field', see ConfigTemplateKey.
+func getMapTemplateKey(field reflect.StructField) (string, bool) {
+	key, ok := field.Tag.Lookup("template")
+	if !ok || key == "-" {
+		return "", false
+	}
+	if key == "" {
+		key = ConfigTemplateKey
+	}
+	return key, key != ""
+}
+
+// getEntryStructType returns the structure type of the entries of map or slice type 't'.
+func getEntryStructType(t reflect.Type) (reflect.Type, bool) {
+	if t.Kind() != reflect.Map && t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+		return nil, false
+	}
+	for t = t.Elem(); t.Kind() == reflect.Ptr; t = t.Elem() {
+	}
+	return t, isNestedStruct(reflect.Zero(t))
+}
+
+// hasMapTemplates reports whether the structure type has maps with template entries, see ConfigTemplateKey.
+// 'visited' prevents infinite recursion for self-referencing types.
+func hasMapTemplates(structType reflect.Type, visited map[reflect.Type]bool) bool {
+	if visited[structType] {
+		return false
+	}
+	visited[structType] = true
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		fieldType := field.Type
+		for fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if _, ok := getMapTemplateKey(field); ok && fieldType.Kind() == reflect.Map {
+			return true
+		}
+		if entryType, ok := getEntryStructType(fieldType); ok {
+			fieldType = entryType
+		}
+		if isNestedStruct(reflect.Zero(fieldType)) && hasMapTemplates(fieldType, visited) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyMapTemplates merges the template entries of maps into other entries of the maps of decoded config 'm'
+// (see ConfigTemplateKey) and removes the template entries. 'structType' is the type of structure
+// the map is stored to. The templates are collected to 'templates' by the paths of map fields
+// ("Device", "Zones[west].Device"), so they apply to the maps decoded later too.
+// It returns 'true' if 'm' is changed.
+func applyMapTemplates(structType reflect.Type, m map[string]interface{}, prefix string,
+	templates map[string]map[string]interface{}) bool {
+	changed := false
+	for key, value := range m {
+		field, ok := findFieldByKey(structType, key)
+		if !ok {
+			continue
+		}
+		fieldType := field.Type
+		for fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		path := prefix + field.Name
+		if isNestedStruct(reflect.Zero(fieldType)) {
+			if table, ok := value.(map[string]interface{}); ok {
+				changed = applyMapTemplates(fieldType, table, path+".", templates) || changed
+			}
+			continue
+		}
+		entryType, ok := getEntryStructType(fieldType)
+		if !ok {
+			continue
+		}
+		if items, ok := value.([]interface{}); ok {
+			for i, item := range items {
+				if table, ok := item.(map[string]interface{}); ok {
+					changed = applyMapTemplates(entryType, table, fmt.Sprintf("%v[%v].", path, i), templates) || changed
+				}
+			}
+			continue
+		}
+		entries, ok := value.(map[string]interface{})
+		if !ok || fieldType.Kind() != reflect.Map {
+			continue
+		}
+		if templateKey, ok := getMapTemplateKey(field); ok {
+			if template, ok := entries[templateKey].(map[string]interface{}); ok {
+				templates[path] = mergeTemplateMap(templates[path], template, entryType)
+			}
+			if _, ok := entries[templateKey]; ok {
+				delete(entries, templateKey)
+				changed = true
+			}
+			if template := templates[path]; template != nil {
+				for name, entry := range entries {
+					if table, ok := entry.(map[string]interface{}); ok {
+						entries[name] = mergeTemplateMap(template, table, entryType)
+						changed = true
+					}
+				}
+			}
+		}
+		for name, entry := range entries {
+			if table, ok := entry.(map[string]interface{}); ok {
+				changed = applyMapTemplates(entryType, table, fmt.Sprintf("%v[%v].", path, name), templates) || changed
+			}
+		}
+	}
+	return changed
+}
+
+// mergeTemplateMap returns the copy of 'template' map with the keys of 'entry' on top of it.
+// The keys referring to the same field of structure type 'structType' are replaced
+// even if they are spelled differently, the nested tables of structure fields are merged.
+func mergeTemplateMap(template, entry map[string]interface{}, structType reflect.Type) map[string]interface{} {
+	merged := copyConfigMap(template)
+	for key, value := range entry {
+		field, isField := findFieldByKey(structType, key)
+		for k := range merged {
+			if k == key {
+				continue
+			}
+			if f, ok := findFieldByKey(structType, k); isField && ok && f.Name == field.Name {
+				merged[key] = merged[k]
+				delete(merged, k)
+			}
+		}
+		fieldType := field.Type
+		for isField && fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		base, baseIsMap := merged[key].(map[string]interface{})
+		table, isMap := value.(map[string]interface{})
+		if isField && baseIsMap && isMap && isNestedStruct(reflect.Zero(fieldType)) {
+			merged[key] = mergeTemplateMap(base, table, fieldType)
+		} else {
+			merged[key] = value
+		}
+	}
+	return merged
+}
+
+// copyConfigMap returns the deep copy of decoded config map, so the entries merged with the template
+// share no nested maps and slices.
+func copyConfigMap(m map[string]interface{}) map[string]interface{} {
+	if m == nil {
+		return map[string]interface{}{}
+	}
+	dst := make(map[string]interface{}, len(m))
+	for key, value := range m {
+		dst[key] = copyConfigMapValue(value)
+	}
+	return dst
+}
+
+func copyConfigMapValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return copyConfigMap(v)
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = copyConfigMapValue(item)
+		}
+		return items
+	}
+	return value
+}
+
+// getTemplateDecoder returns the decoder applying the templates of maps to the config decoded
+// by 'decoder' (see ConfigTemplateKey). The file with templates is stored to the config structure
+// by ParseMapToStruct rules.
+func (l *configLoader) getTemplateDecoder(decoder ConfigDecoder) ConfigDecoder {
+	return func(data []byte, v interface{}) error {
+		var m map[string]interface{}
+		if err := decoder(data, &m); err != nil {
+			return err
+		}
+		changed := applyMapTemplates(reflect.TypeOf(l.config).Elem(), m, "", l.templates)
+		if _, isMap := v.(*map[string]interface{}); !isMap && !changed {
+			return decoder(data, v)
+		}
+		return storeConfigMap(m, v)
+	}
+}
@@ -28,3 +28,13 @@ func NormalizePath(path string) (string, error) {
 	}
 	return filepath.Abs(path)
 }
+
+// IsDirExists reports whether 'path' (see NormalizePath) is an existing directory.
+func IsDirExists(path string) bool {
+	if path, err := NormalizePath(path); err == nil {
+		if info, err := os.Stat(path); err == nil {
+			return info.IsDir()
+		}
+	}
+	return false
+}
@@ -0,0 +1,249 @@
+package yagolib
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Logger receives diagnostic messages of Loader. The messages have no trailing newline.
+type Logger interface {
+	// Infof reports the progress of loading: the files and variables applied.
+	Infof(format string, args ...interface{})
+	// Warnf reports missing files and errors.
+	Warnf(format string, args ...interface{})
+}
+
+// writerLogger writes the messages to io.Writer-s line by line.
+type writerLogger struct {
+	info io.Writer
+	warn io.Writer
+}
+
+// NewWriterLogger returns the Logger writing info messages to 'info' and warnings to 'warn'.
+// 'nil' writer discards the messages.
+func NewWriterLogger(info, warn io.Writer) Logger {
+	return &writerLogger{info: info, warn: warn}
+}
+
+func (l *writerLogger) Infof(format string, args ...interface{}) {
+	if l.info != nil {
+		fmt.Fprintln(l.info, strings.TrimRight(fmt.Sprintf(format, args...), "\n"))
+	}
+}
+
+func (l *writerLogger) Warnf(format string, args ...interface{}) {
+	if l.warn != nil {
+		fmt.Fprintln(l.warn, strings.TrimRight(fmt.Sprintf(format, args...), "\n"))
+	}
+}
+
+// Loader loads the config from files, environment variables and command line
+// like LoadConfig does. It is created by NewLoader and configured by LoaderOption-s:
+//
+//	loader := yagolib.NewLoader(
+//		yagolib.WithHomeConfig("config.toml"),
+//		yagolib.WithCmdLine(os.Args[1:]),
+//		yagolib.WithEnvPrefix("MYAPP"),
+//		yagolib.WithLogger(logger),
+//	)
+//	err := loader.Load(&config)
+//
+// The options not given are taken from package variables (ConfigFormat, ConfigEnvPrefix,
+// ConfigSearchDirs, ConfigSearchPolicy, ConfigResolveReferences, ConfigLenient, ConfigUnknownKeys
+// and ConfigProfile)
+// at the moment of NewLoader call.
+// Loader is not modified by loading, so it may be used concurrently.
+type Loader struct {
+	homeConfigName    string
+	configPath        string
+	readers           []*readerSource
+	embedded          []embeddedSource
+	cmdLine           []string
+	format            string
+	decoders          map[string]ConfigDecoder
+	envPrefix         string
+	searchDirs        []string
+	searchPolicy      SearchPolicy
+	strict            bool
+	unknownKeys       UnknownKeysPolicy
+	profile           string
+	resolveReferences bool
+	lenient           bool
+	logger            Logger
+	output            io.Writer // the usage text and other output requested by command line
+	onFilesLoaded     func(paths []string)
+	migrations        []configMigration // sorted by version
+	rewriteMigrated   bool
+	warned            *messageSet // the warnings reported once, see warnOnce
+}
+
+// LoaderOption configures Loader.
+type LoaderOption func(*Loader)
+
+// NewLoader returns the Loader configured by 'options'.
+func NewLoader(options ...LoaderOption) *Loader {
+	ld := &Loader{
+		format:            ConfigFormat,
+		envPrefix:         ConfigEnvPrefix,
+		searchDirs:        ConfigSearchDirs,
+		searchPolicy:      ConfigSearchPolicy,
+		resolveReferences: ConfigResolveReferences,
+		lenient:           ConfigLenient,
+		unknownKeys:       ConfigUnknownKeys,
+		profile:           ConfigProfile,
+		output:            os.Stdout,
+		warned:            &messageSet{},
+	}
+	for _, option := range options {
+		option(ld)
+	}
+	return ld
+}
+
+// WithHomeConfig sets the name of config file searched in the config search directories.
+func WithHomeConfig(name string) LoaderOption {
+	return func(ld *Loader) { ld.homeConfigName = name }
+}
+
+// WithConfigFile sets the path of config file loaded after the files found in the search directories.
+// ConfigStdinPath ("-") means the standard input.
+func WithConfigFile(path string) LoaderOption {
+	return func(ld *Loader) { ld.configPath = path }
+}
+
+// WithCmdLine sets the command line arguments (without the name of executable).
+// The command line is not parsed if this option is not given.
+func WithCmdLine(args []string) LoaderOption {
+	return func(ld *Loader) { ld.cmdLine = args }
+}
+
+// WithFormat sets the format of all config files, see ConfigFormat.
+func WithFormat(format string) LoaderOption {
+	return func(ld *Loader) { ld.format = format }
+}
+
+// WithDecoder sets the 'decoder' of files with extension 'format' for this Loader only.
+// See RegisterConfigDecoder.
+func WithDecoder(format string, decoder ConfigDecoder) LoaderOption {
+	return func(ld *Loader) {
+		decoders := map[string]ConfigDecoder{}
+		for f, d := range ld.decoders {
+			decoders[f] = d
+		}
+		decoders[strings.ToLower(strings.TrimPrefix(format, "."))] = decoder
+		ld.decoders = decoders
+	}
+}
+
+// WithEnvPrefix sets the prefix of environment variables, see ConfigEnvPrefix.
+func WithEnvPrefix(prefix string) LoaderOption {
+	return func(ld *Loader) { ld.envPrefix = prefix }
+}
+
+// WithoutEnv disables the environment variables layer.
+func WithoutEnv() LoaderOption {
+	return WithEnvPrefix("-")
+}
+
+// WithSearchDirs sets the config search directories, see ConfigSearchDirs.
+func WithSearchDirs(dirs ...string) LoaderOption {
+	return func(ld *Loader) { ld.searchDirs = dirs }
+}
+
+// WithSearchPolicy sets the policy of loading the files found in the search directories.
+func WithSearchPolicy(policy SearchPolicy) LoaderOption {
+	return func(ld *Loader) { ld.searchPolicy = policy }
+}
+
+// WithStrict enables strict loading: the file given by WithConfigFile must exist
+// and unknown keys are errors (UnknownKeysFail policy).
+func WithStrict(strict bool) LoaderOption {
+	return func(ld *Loader) {
+		ld.strict = strict
+		if strict {
+			ld.unknownKeys = UnknownKeysFail
+		}
+	}
+}
+
+// WithUnknownKeys sets the policy for the config keys and environment variables
+// which do not match any config field.
+func WithUnknownKeys(policy UnknownKeysPolicy) LoaderOption {
+	return func(ld *Loader) { ld.unknownKeys = policy }
+}
+
+// WithResolveReferences enables or disables the resolution of references in string values,
+// see ResolveConfigValue. The references are not resolved by default, see ConfigResolveReferences.
+func WithResolveReferences(resolve bool) LoaderOption {
+	return func(ld *Loader) { ld.resolveReferences = resolve }
+}
+
+// WithLenient enables or disables lenient decoding of config files by ParseMapToStruct rules,
+// see ConfigLenient.
+func WithLenient(lenient bool) LoaderOption {
+	return func(ld *Loader) { ld.lenient = lenient }
+}
+
+// WithLogger sets the logger of diagnostic messages. Loader is silent without logger.
+func WithLogger(logger Logger) LoaderOption {
+	return func(ld *Loader) { ld.logger = logger }
+}
+
+// WithOutput sets the writer of the output requested by command line: the usage text printed
+// for '-h' or '--help' flag, the shell completion script (see ConfigCompletionFlag) and the message
+// about the default config written (see ConfigWriteDefaultFlag).
+// It is os.Stdout by default, 'nil' discards the output.
+func WithOutput(w io.Writer) LoaderOption {
+	return func(ld *Loader) { ld.output = w }
+}
+
+// WithLoadedFilesFunc sets the function called by Load with the paths of config files loaded successfully
+// in the order of loading: the files found in the search directories, the file given by WithConfigFile,
+// their included files and drop-ins. The files failed to decode, embedded files and readers are not listed.
+// The function is called after loading all sources, even if there are errors.
+func WithLoadedFilesFunc(fn func(paths []string)) LoaderOption {
+	return func(ld *Loader) { ld.onFilesLoaded = fn }
+}
+
+// WithVerbose makes Loader print the diagnostic messages to stdout and stderr
+// like LoadConfig does with 'verbose' argument set.
+func WithVerbose(verbose bool) LoaderOption {
+	return func(ld *Loader) {
+		ld.logger = nil
+		if verbose {
+			ld.logger = NewWriterLogger(os.Stdout, os.Stderr)
+		}
+	}
+}
+
+// Load loads the config into the structure pointed to by 'config', see LoadConfig for the order of layers.
+// The loaded config is validated by ValidateStruct and by Validate method if 'config' implements ConfigValidator.
+// The loading errors are returned as ConfigError.
+func (ld *Loader) Load(config interface{}) error {
+	l := configLoader{Loader: ld, config: config, prov: Provenance{}}
+	return l.load()
+}
+
+// LoadWithProvenance loads the config like Load does and returns the provenance:
+// the source of the value of every config field. See PrintProvenance.
+func (ld *Loader) LoadWithProvenance(config interface{}) (Provenance, error) {
+	l := configLoader{Loader: ld, config: config, prov: Provenance{}}
+	err := l.load()
+	return l.prov, err
+}
+
+// getDecoder returns the decoder of config file 'path'.
+func (ld *Loader) getDecoder(path string) (ConfigDecoder, error) {
+	format := getConfigFormatName(path, ld.format)
+	if decoder, ok := ld.decoders[format]; ok {
+		return decoder, nil
+	}
+	return GetConfigDecoder(path, ld.format)
+}
+
+// getEnvPrefix returns the prefix of environment variables, see GetConfigEnvPrefix.
+func (ld *Loader) getEnvPrefix() string {
+	return getConfigEnvPrefix(ld.envPrefix)
+}
@@ -0,0 +1,189 @@
+package yagolib
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// StoreChangeFunc is called by Store after the config is replaced.
+// 'changedFields' contains the paths of changed fields, like "DB.Host".
+type StoreChangeFunc[T any] func(oldConfig, newConfig *T, changedFields []string)
+
+// Store holds the config of type T shared by many goroutines:
+//
+//	store := yagolib.NewStore(&Config{Port: 8080})
+//	if err := store.Load(yagolib.NewLoader(yagolib.WithHomeConfig("config.toml"))); err != nil { ... }
+//	port := store.Get().Port
+//
+// Get returns the current config without locking. The config returned by Get is never modified:
+// Set, Update and Load replace the whole config atomically, so a reader never observes
+// a partially applied config. The subscribers are notified after the config is replaced,
+// the notifications are delivered one by one in the order of changes. A subscriber must not call
+// Set, Update or Load of the same Store.
+type Store[T any] struct {
+	current atomic.Value // *T
+	base    *T           // the config before loading: the defaults set by application
+
+	mu       sync.Mutex // serializes the writers
+	notifyMu sync.Mutex // serializes the notifications in the order of changes
+	subsMu   sync.Mutex
+	subs     map[int]StoreChangeFunc[T]
+	nextID   int
+}
+
+// NewStore returns the Store holding the copy of 'initial' config.
+// The copy is also the base of configs loaded by Load.
+func NewStore[T any](initial *T) *Store[T] {
+	s := &Store[T]{subs: map[int]StoreChangeFunc[T]{}}
+	if initial == nil {
+		initial = new(T)
+	}
+	s.base = deepCopy(initial)
+	s.current.Store(deepCopy(initial))
+	return s
+}
+
+// Get returns the snapshot of the current config. The snapshot must not be modified.
+func (s *Store[T]) Get() *T {
+	return s.current.Load().(*T)
+}
+
+// Set replaces the config by 'config' which must not be modified after the call.
+func (s *Store[T]) Set(config *T) {
+	s.mu.Lock()
+	old := s.Get()
+	s.current.Store(config)
+	s.notifyMu.Lock() // taken before unlocking the writers to keep the order of notifications
+	s.mu.Unlock()
+	defer s.notifyMu.Unlock()
+	s.notify(old, config)
+}
+
+// Update applies 'fn' to the copy of the current config and replaces the config by the copy.
+// The concurrent updates are serialized, so none of them is lost.
+func (s *Store[T]) Update(fn func(config *T)) {
+	s.mu.Lock()
+	old := s.Get()
+	config := deepCopy(old)
+	fn(config)
+	s.current.Store(config)
+	s.notifyMu.Lock() // taken before unlocking the writers to keep the order of notifications
+	s.mu.Unlock()
+	defer s.notifyMu.Unlock()
+	s.notify(old, config)
+}
+
+// Load loads the new config by 'loader' into the copy of the base config and replaces the current config.
+// The current config is kept if loading fails.
+func (s *Store[T]) Load(loader *Loader) error {
+	config := deepCopy(s.base)
+	if err := loader.Load(config); err != nil {
+		return err
+	}
+	s.Set(config)
+	return nil
+}
+
+// Watch loads the config by 'loader' and replaces the current config every time
+// the config files are changed, see WatchConfig.
+func (s *Store[T]) Watch(loader *Loader, onError func(error)) (*ConfigWatcher, error) {
+	config := deepCopy(s.base)
+	w, err := loader.Watch(config, func(oldConfig, newConfig interface{}, changedFields []string) {
+		s.Set(newConfig.(*T))
+	}, onError)
+	if err != nil {
+		return nil, err
+	}
+	s.Set(config)
+	return w, nil
+}
+
+// Subscribe registers 'fn' called after every change of config.
+// The returned function cancels the subscription.
+func (s *Store[T]) Subscribe(fn StoreChangeFunc[T]) (unsubscribe func()) {
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+	id := s.nextID
+	s.nextID++
+	s.subs[id] = fn
+	return func() {
+		s.subsMu.Lock()
+		defer s.subsMu.Unlock()
+		delete(s.subs, id)
+	}
+}
+
+// notify calls the subscribers if the config is changed.
+func (s *Store[T]) notify(oldConfig, newConfig *T) {
+	changed := GetChangedFields(oldConfig, newConfig)
+	if len(changed) == 0 {
+		return
+	}
+	s.subsMu.Lock()
+	subs := make([]StoreChangeFunc[T], 0, len(s.subs))
+	for _, fn := range s.subs {
+		subs = append(subs, fn)
+	}
+	s.subsMu.Unlock()
+	for _, fn := range subs {
+		fn(oldConfig, newConfig, changed)
+	}
+}
+
+// deepCopy returns the copy of value pointed to by 'src' which shares no slices, maps
+// and pointers with the original.
+func deepCopy[T any](src *T) *T {
+	dst := new(T)
+	deepCopyValue(reflect.ValueOf(dst).Elem(), reflect.ValueOf(src).Elem())
+	return dst
+}
+
+func deepCopyValue(dst, src reflect.Value) {
+	switch src.Kind() {
+	case reflect.Ptr:
+		if !src.IsNil() {
+			dst.Set(reflect.New(src.Type().Elem()))
+			deepCopyValue(dst.Elem(), src.Elem())
+		}
+	case reflect.Slice:
+		if !src.IsNil() {
+			dst.Set(reflect.MakeSlice(src.Type(), src.Len(), src.Len()))
+			for i := 0; i < src.Len(); i++ {
+				deepCopyValue(dst.Index(i), src.Index(i))
+			}
+		}
+	case reflect.Array:
+		for i := 0; i < src.Len(); i++ {
+			deepCopyValue(dst.Index(i), src.Index(i))
+		}
+	case reflect.Map:
+		if !src.IsNil() {
+			dst.Set(reflect.MakeMapWithSize(src.Type(), src.Len()))
+			for _, key := range src.MapKeys() {
+				value := reflect.New(src.Type().Elem()).Elem()
+				deepCopyValue(value, src.MapIndex(key))
+				dst.SetMapIndex(key, value)
+			}
+		}
+	case reflect.Struct:
+		if !dst.CanSet() || !isNestedStruct(src) {
+			dst.Set(src)
+			return
+		}
+		dst.Set(src) // unexported fields are copied as is
+		for i := 0; i < src.NumField(); i++ {
+			if dst.Field(i).CanSet() {
+				deepCopyValue(dst.Field(i), src.Field(i))
+			}
+		}
+	case reflect.Interface:
+		if !src.IsNil() {
+			value := reflect.New(src.Elem().Type()).Elem()
+			deepCopyValue(value, src.Elem())
+			dst.Set(value)
+		}
+	default:
+		dst.Set(src)
+	}
+}
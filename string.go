@@ -36,3 +36,27 @@ func GetBaseOfIntString(intStr string) int {
 	}
 	return 10
 }
+
+// ToUpperSnakeCase converts 'str' to the form used by environment variable names:
+// "dbHost" -> "DB_HOST", "HTTPPort" -> "HTTP_PORT", "my-app" -> "MY_APP".
+// Any char that is not a letter or a digit is replaced by '_'.
+func ToUpperSnakeCase(str string) string {
+	runes := []rune(str)
+	var sb strings.Builder
+	for i, r := range runes {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			r = '_'
+		} else if unicode.IsUpper(r) && i > 0 {
+			prev := runes[i-1]
+			nextIsLower := (i+1 < len(runes)) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextIsLower) {
+				sb.WriteRune('_')
+			}
+		}
+		if r == '_' && strings.HasSuffix(sb.String(), "_") {
+			continue
+		}
+		sb.WriteRune(unicode.ToUpper(r))
+	}
+	return strings.Trim(sb.String(), "_")
+}
@@ -0,0 +1,262 @@
+package yagolib
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ConfigValidator may be implemented by the config structure to check the loaded values
+// in addition to the rules of `validate:"..."` tags.
+type ConfigValidator interface {
+	Validate() error
+}
+
+// FieldError describes the violation of validation rule by the structure field.
+type FieldError struct {
+	Field   string // path of the field: "DB.Port", "Servers[1].Host"
+	Rule    string // violated rule: "required", "min=1", ...
+	Value   interface{}
+	Message string
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("Field '%v' %v", e.Field, e.Message)
+}
+
+// ValidationErrors is the list of all violations found by ValidateStruct.
+type ValidationErrors []*FieldError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// ValidateStruct checks the fields of structure pointed to by 'structPtr' against
+// the rules defined by `validate:"..."` tags. The rules are separated by commas:
+//
+//	required       - the value must not be zero (empty string, 'nil' pointer or slice, ...)
+//	min=N, max=N   - the limits of number or the limits of length of string, slice or map
+//	oneof=a|b|c    - the value must be one of listed
+//	pattern=REGEX  - the value must match regular expression (must be the last rule of the tag)
+//
+//	type Config struct {
+//		Port int    `validate:"required,min=1,max=65535"`
+//		Mode string `validate:"oneof=dev|prod"`
+//	}
+//
+// The limits of 'min' and 'max' are converted to the field type by TryToConvert.
+// The 'oneof' and 'pattern' rules of slice field are applied to every element.
+// Nested structures, slices and maps of structures and pointers are checked recursively,
+// the paths of fields of entries contain the index or the key of entry: "Servers[1].Host", "Device[camera].Address".
+// All violations are returned as ValidationErrors, or 'nil' if the structure is valid.
+func ValidateStruct(structPtr interface{}) error {
+	v := reflect.Indirect(reflect.ValueOf(structPtr))
+	if v.Kind() != reflect.Struct {
+		return fmt.Errorf("'structPtr' must be pointer to structure")
+	}
+	var errs ValidationErrors
+	validateStruct(v, "", &errs)
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+func validateStruct(structValue reflect.Value, prefix string, errs *ValidationErrors) {
+	structType := structValue.Type()
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		fieldValue := structValue.Field(i)
+		if !fieldValue.CanInterface() {
+			continue
+		}
+		validateField(fieldValue, prefix+field.Name, parseValidateTag(field.Tag.Get("validate")), errs)
+	}
+}
+
+// parseValidateTag splits the rules of tag. The 'pattern' rule takes the rest of the tag
+// because regular expression may contain commas.
+func parseValidateTag(tag string) []string {
+	var rules []string
+	for tag != "" {
+		if strings.HasPrefix(tag, "pattern=") {
+			return append(rules, tag)
+		}
+		i := strings.Index(tag, ",")
+		if i < 0 {
+			i = len(tag)
+		}
+		if rule := strings.TrimSpace(tag[:i]); rule != "" {
+			rules = append(rules, rule)
+		}
+		tag = strings.TrimPrefix(tag[i:], ",")
+	}
+	return rules
+}
+
+func validateField(v reflect.Value, path string, rules []string, errs *ValidationErrors) {
+	addError := func(rule, msg string) {
+		*errs = append(*errs, &FieldError{Field: path, Rule: rule, Value: v.Interface(), Message: msg})
+	}
+	addElemError := func(i int, elem reflect.Value, rule, msg string) {
+		elemPath := path
+		if i >= 0 {
+			elemPath = fmt.Sprintf("%v[%v]", path, i)
+		}
+		*errs = append(*errs, &FieldError{Field: elemPath, Rule: rule, Value: elem.Interface(), Message: msg})
+	}
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			for _, rule := range rules {
+				if rule == "required" {
+					addError(rule, "is required")
+				}
+			}
+			return
+		}
+		v = v.Elem()
+	} else {
+		for _, rule := range rules {
+			if rule == "required" && v.IsZero() {
+				addError(rule, "is required")
+				return // other rules make no sense for missing value
+			}
+		}
+	}
+
+	for _, rule := range rules {
+		name, arg := rule, ""
+		if i := strings.Index(rule, "="); i >= 0 {
+			name, arg = rule[:i], rule[i+1:]
+		}
+		switch name {
+		case "required":
+		case "min", "max":
+			if msg := checkLimit(v, name, arg); msg != "" {
+				addError(rule, msg)
+			}
+		case "oneof", "pattern":
+			var re *regexp.Regexp
+			if name == "pattern" {
+				var err error
+				if re, err = regexp.Compile(arg); err != nil {
+					addError(rule, fmt.Sprintf("has invalid rule '%v': %v", rule, err))
+					continue
+				}
+			}
+			check := func(i int, value reflect.Value) {
+				str := fmt.Sprint(value.Interface())
+				if re != nil && !re.MatchString(str) {
+					addElemError(i, value, rule, fmt.Sprintf("value '%v' does not match pattern '%v'", str, arg))
+				} else if re == nil && !isOneOf(str, strings.Split(arg, "|")) {
+					addElemError(i, value, rule,
+						fmt.Sprintf("value '%v' is not one of: %v", str, strings.Replace(arg, "|", ", ", -1)))
+				}
+			}
+			if (v.Kind() == reflect.Slice || v.Kind() == reflect.Array) && v.Type().Elem().Kind() != reflect.Uint8 {
+				for i := 0; i < v.Len(); i++ {
+					check(i, v.Index(i))
+				}
+			} else {
+				check(-1, v)
+			}
+		default:
+			addError(rule, fmt.Sprintf("has unknown validation rule '%v'", rule))
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		if isNestedStruct(v) {
+			validateStruct(v, path+".", errs)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			elem := reflect.Indirect(v.Index(i))
+			if isNestedStruct(elem) {
+				validateStruct(elem, fmt.Sprintf("%v[%v].", path, i), errs)
+			}
+		}
+	case reflect.Map:
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })
+		for _, key := range keys {
+			elem := reflect.Indirect(v.MapIndex(key))
+			if isNestedStruct(elem) {
+				validateStruct(elem, fmt.Sprintf("%v[%v].", path, key), errs)
+			}
+		}
+	}
+}
+
+func isOneOf(str string, values []string) bool {
+	for _, value := range values {
+		if str == value {
+			return true
+		}
+	}
+	return false
+}
+
+// checkLimit checks 'min'/'max' rule and returns the description of violation or empty string.
+func checkLimit(v reflect.Value, rule, arg string) string {
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+		var limit int
+		if err := TryToConvert(arg, &limit, nil); err != nil {
+			return fmt.Sprintf("has invalid rule '%v=%v': %v", rule, arg, err)
+		}
+		if cmp := compareInts(int64(v.Len()), int64(limit)); (rule == "min" && cmp < 0) || (rule == "max" && cmp > 0) {
+			return fmt.Sprintf("length %v is out of limit %v=%v", v.Len(), rule, arg)
+		}
+		return ""
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		limit := reflect.New(v.Type())
+		if err := TryToConvert(arg, limit.Interface(), nil); err != nil {
+			return fmt.Sprintf("has invalid rule '%v=%v': %v", rule, arg, err)
+		}
+		if cmp := compareNumbers(v, limit.Elem()); (rule == "min" && cmp < 0) || (rule == "max" && cmp > 0) {
+			return fmt.Sprintf("value %v is out of limit %v=%v", v.Interface(), rule, arg)
+		}
+		return ""
+	}
+	return fmt.Sprintf("has rule '%v' not applicable to type %v", rule, v.Type())
+}
+
+func compareInts(a, b int64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+// compareNumbers returns the sign of (a - b) for numbers of the same kind.
+func compareNumbers(a, b reflect.Value) int {
+	switch a.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return compareInts(a.Int(), b.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if a.Uint() < b.Uint() {
+			return -1
+		} else if a.Uint() > b.Uint() {
+			return 1
+		}
+	default:
+		if a.Float() < b.Float() {
+			return -1
+		} else if a.Float() > b.Float() {
+			return 1
+		}
+	}
+	return 0
+}
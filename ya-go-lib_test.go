@@ -1,11 +1,19 @@
 package yagolib
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"reflect"
+	"regexp"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
 	"time"
 )
 
@@ -35,6 +43,21 @@ func TestRemoveCharacters(t *testing.T) {
 	}
 }
 
+func TestToUpperSnakeCase(t *testing.T) {
+	type test struct {
+		in, out string
+	}
+	tests := [...]test{
+		{"dbHost", "DB_HOST"}, {"HTTPPort", "HTTP_PORT"}, {"my-app", "MY_APP"},
+		{"Db_Host", "DB_HOST"}, {"Value1", "VALUE1"}, {"", ""}}
+	for _, tt := range tests {
+		result := ToUpperSnakeCase(tt.in)
+		if result != tt.out {
+			t.Errorf("ToUpperSnakeCase(%v) returned '%v'; expected: '%v'", tt.in, result, tt.out)
+		}
+	}
+}
+
 func TestGetBaseOfIntString(t *testing.T) {
 	type test struct {
 		in  string
@@ -63,6 +86,9 @@ func TestTryToConvert(t *testing.T) {
 	var f32 float32
 	var f64 float64
 	var tm time.Time
+	var d time.Duration
+	var si []int
+	var ss []string
 	var dstNotSupported interface{}
 	tests := [...]test{
 		{"'dst' not pointer", 34, nil, nil}, // out=nil - so the function being tested must return error
@@ -110,6 +136,16 @@ func TestTryToConvert(t *testing.T) {
 		{"1976", &s, nil, "1976"},
 		{1976, &s, nil, "1976"},
 		{19.76, &s, nil, "19.76"},
+		{"1m30s", &d, nil, "1m30s"},
+		{1500, &d, nil, "1.5µs"},
+		{"error", &d, nil, nil},
+		{"1, 0x2, 3", &si, nil, "[1 2 3]"},
+		{[]interface{}{int64(1), "2"}, &si, nil, "[1 2]"},
+		{"1,error", &si, nil, nil},
+		{`"a", 'b'`, &ss, nil, "[a b]"},
+		{`["a", "b,c"]`, &ss, nil, "[a b,c]"},
+		{"[1, 0x2]", &si, nil, "[1 2]"},
+		{"[a]", &ss, nil, nil},
 		{"target type not supported", &dstNotSupported, nil, nil}}
 	for _, tt := range tests {
 		in1 := fmt.Sprint(tt.in1)
@@ -215,3 +251,2034 @@ func TestParseMapToStruct(t *testing.T) {
 		}
 	}
 }
+
+func TestLoadConfigFormats(t *testing.T) {
+	type dbConfig struct {
+		Host string
+		Port int
+	}
+	type config struct {
+		Name    string
+		Enabled bool
+		DB      dbConfig
+	}
+	type test struct {
+		fileName string
+		content  string
+	}
+	tests := [...]test{
+		{"config.toml", "name = \"test\"\nenabled = true\n[db]\nhost = \"localhost\"\nport = 5432\n"},
+		{"config.json", `{"name": "test", "enabled": true, "db": {"host": "localhost", "port": 5432}}`},
+		{"config.yaml", "name: test\nenabled: true\ndb:\n  host: localhost\n  port: 5432\n"},
+		{"config.ini", "name = test\nenabled = yes\n; comment\n[db]\nhost = localhost\nport = 0x1538\n"},
+		{"config.env", "# comment\nNAME=\"test\"\nexport ENABLED=on\nDB_HOST=localhost\nDB_PORT=5432\n"},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.fileName)
+		if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		var cfg config
+		if err := LoadConfig(&cfg, "", path, nil, false); err != nil {
+			t.Errorf("LoadConfig(%v) returned error: %v", tt.fileName, err)
+			continue
+		}
+		expected := config{"test", true, dbConfig{"localhost", 5432}}
+		if cfg != expected {
+			t.Errorf("LoadConfig(%v) returned config %+v; expected: %+v", tt.fileName, cfg, expected)
+		}
+	}
+}
+
+func TestParseEnvToStruct(t *testing.T) {
+	type config struct {
+		Port    uint16
+		Verbose bool
+		Skipped string `env:"-"`
+		DB      struct {
+			Host    string
+			MaxConn int `env:"MAX_CONNECTIONS"`
+		} `env:"DATABASE"`
+	}
+	t.Setenv("TESTAPP_PORT", "0x1F90")
+	t.Setenv("TESTAPP_VERBOSE", "yes")
+	t.Setenv("TESTAPP_SKIPPED", "value")
+	t.Setenv("TESTAPP_DATABASE_HOST", "db.local")
+	t.Setenv("TESTAPP_DATABASE_MAX_CONNECTIONS", "16")
+	var cfg config
+	n, err := ParseEnvToStruct("TESTAPP", &cfg)
+	if err != nil || n != 4 {
+		t.Fatalf("ParseEnvToStruct returned (%v, %v); expected: (4, nil)", n, err)
+	}
+	if cfg.Port != 8080 || !cfg.Verbose || cfg.Skipped != "" || cfg.DB.Host != "db.local" || cfg.DB.MaxConn != 16 {
+		t.Errorf("ParseEnvToStruct set config to %+v", cfg)
+	}
+	t.Setenv("TESTAPP_PORT", "error")
+	if _, err = ParseEnvToStruct("TESTAPP", &cfg); err == nil {
+		t.Errorf("ParseEnvToStruct returned nil; expected: error")
+	}
+}
+
+func TestParseCmdLineToStruct(t *testing.T) {
+	type dbConfig struct {
+		MaxConn int
+	}
+	type config struct {
+		Name    string
+		Port    int  `short:"p"`
+		Verbose bool `short:"v"`
+		Quiet   bool `short:"q"`
+		Color   bool
+		DB      dbConfig
+	}
+	type test struct {
+		cmdLine []string
+		out     config
+		exErr   bool
+	}
+	tests := [...]test{
+		{[]string{"--name", "test", "--port=8080"}, config{Name: "test", Port: 8080, Color: true}, false},
+		{[]string{"-p", "0x1F90", "-vq", "--no-color"}, config{Port: 8080, Verbose: true, Quiet: true}, false},
+		{[]string{"-p8080", "--db.max-conn", "4", "--verbose=false"}, config{Port: 8080, Color: true, DB: dbConfig{4}}, false},
+		{[]string{"Name=\"old style\"", "--DbMaxConn=4"}, config{Name: "old style", Color: true, DB: dbConfig{4}}, false},
+		{[]string{"--port"}, config{Color: true}, true},
+		{[]string{"--unknown"}, config{Color: true}, true},
+		{[]string{"-p", "error"}, config{Color: true}, true},
+		{[]string{"--", "-p"}, config{Color: true}, true},
+	}
+	for i, tt := range tests {
+		cfg := config{Color: true}
+		_, err := ParseCmdLineToStruct(tt.cmdLine, &cfg)
+		if (err != nil) != tt.exErr || cfg != tt.out {
+			t.Errorf("Test %v: ParseCmdLineToStruct(%q) returned config %+v, error: %v; expected: %+v",
+				i, tt.cmdLine, cfg, err, tt.out)
+		}
+	}
+	var tags struct {
+		Tags []string
+	}
+	if _, err := ParseCmdLineToStruct([]string{`Tags=["a","b"]`}, &tags); err != nil || fmt.Sprint(tags.Tags) != "[a b]" {
+		t.Errorf("ParseCmdLineToStruct of old style array returned %+v, %v", tags, err)
+	}
+	var ce *ConfigError
+	if _, err := ParseCmdLineToStruct([]string{`Tags=["a",b]`}, &tags); !errors.As(err, &ce) {
+		t.Errorf("ParseCmdLineToStruct of invalid array returned %v", err)
+	}
+	var cfg config
+	if _, err := ParseCmdLineToStruct([]string{"--help"}, &cfg); err != ErrHelp {
+		t.Errorf("ParseCmdLineToStruct(--help) returned %v; expected: ErrHelp", err)
+	}
+	if usage := GetCmdLineUsage("app", &cfg); !strings.Contains(usage, "-p, --port <int>") {
+		t.Errorf("GetCmdLineUsage returned:\n%v", usage)
+	}
+}
+
+func TestWatchConfig(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("inotify is available on Linux only")
+	}
+	type config struct {
+		Name string
+		Port int
+	}
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte("name = \"test\"\nport = 80\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	changes := make(chan []string, 1)
+	errs := make(chan error, 1)
+	onChange := func(oldConfig, newConfig interface{}, changedFields []string) {
+		if oldConfig.(*config).Port != 80 || newConfig.(*config).Port != 8080 {
+			t.Errorf("onChange got old config %+v, new config %+v", oldConfig, newConfig)
+		}
+		changes <- changedFields
+	}
+	cfg := config{Port: 1}
+	w, err := WatchConfig(&cfg, "", path, nil, false, onChange, func(err error) { errs <- err })
+	if err != nil {
+		t.Fatalf("WatchConfig returned error: %v", err)
+	}
+	defer w.Close()
+
+	if err = os.WriteFile(path, []byte("name = \"test\"\nport = 8080\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case changed := <-changes:
+		if fmt.Sprint(changed) != "[Port]" {
+			t.Errorf("onChange got changed fields %v; expected: [Port]", changed)
+		}
+	case err = <-errs:
+		t.Fatalf("onError got: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("onChange was not called")
+	}
+
+	if err = os.WriteFile(path, []byte("port = \"broken\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case changed := <-changes:
+		t.Errorf("onChange was called for broken config with %v", changed)
+	case <-errs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("onError was not called")
+	}
+	if current := w.Config().(*config); current.Port != 8080 {
+		t.Errorf("Config() returned %+v after broken edit; expected port: 8080", current)
+	}
+}
+
+func TestWatchConfigDropIns(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("inotify is available on Linux only")
+	}
+	type config struct {
+		Port int
+	}
+	path := filepath.Join(t.TempDir(), "config.toml") // neither the file nor its drop-in directory exist
+	ports := make(chan int, 1)
+	onChange := func(oldConfig, newConfig interface{}, changedFields []string) {
+		ports <- newConfig.(*config).Port
+	}
+	var cfg config
+	w, err := WatchConfig(&cfg, "", path, nil, false, onChange, func(err error) { t.Errorf("onError got: %v", err) })
+	if err != nil {
+		t.Fatalf("WatchConfig returned error: %v", err)
+	}
+	defer w.Close()
+
+	if err = os.Mkdir(path+".d", 0755); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(3 * configReloadDelay) // the reload on creation of empty directory changes nothing
+	for _, port := range []int{1, 2} {
+		if err = os.WriteFile(filepath.Join(path+".d", "10-port.toml"), []byte(fmt.Sprintf("port = %v\n", port)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case got := <-ports:
+			if got != port {
+				t.Errorf("onChange got port %v; expected: %v", got, port)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("onChange was not called for port %v", port)
+		}
+	}
+}
+
+func TestConfigWatcherReload(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("inotify is available on Linux only")
+	}
+	type config struct {
+		Peers map[string]string
+	}
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte("[peers]\na = \"1\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	loader := NewLoader(WithConfigFile(path), WithoutEnv())
+	var changes []string
+	onChange := func(oldConfig, newConfig interface{}, changedFields []string) {
+		changes = append(changes, fmt.Sprint(oldConfig.(*config).Peers, newConfig.(*config).Peers, changedFields))
+	}
+	cfg := config{Peers: map[string]string{"x": "0"}}
+	w, err := loader.Watch(&cfg, onChange, nil)
+	if err != nil {
+		t.Fatalf("Watch returned error: %v", err)
+	}
+	w.Close() // the reloads are started by the test only
+
+	// the removed key disappears, the old config is not modified
+	if err = os.WriteFile(path, []byte("[peers]\nb = \"2\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Reload(); err != nil {
+		t.Fatalf("Reload returned error: %v", err)
+	}
+	if expected := "[map[a:1 x:0] map[b:2 x:0] [Peers]]"; fmt.Sprint(changes) != expected {
+		t.Errorf("onChange got %v; expected: %v", changes, expected)
+	}
+	if fmt.Sprint(cfg.Peers) != "map[a:1 x:0]" {
+		t.Errorf("Reload modified the initial config: %+v", cfg)
+	}
+
+	// the snapshots of Store are not modified by the reloads
+	if err = os.WriteFile(path, []byte("[peers]\na = \"1\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store := NewStore(&config{Peers: map[string]string{"x": "0"}})
+	if w, err = store.Watch(loader, nil); err != nil {
+		t.Fatalf("Store.Watch returned error: %v", err)
+	}
+	w.Close()
+	snapshot := store.Get()
+	if err = os.WriteFile(path, []byte("[peers]\nb = \"2\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Reload(); err != nil {
+		t.Fatalf("Reload returned error: %v", err)
+	}
+	if fmt.Sprint(snapshot.Peers) != "map[a:1 x:0]" || fmt.Sprint(store.Get().Peers) != "map[b:2 x:0]" {
+		t.Errorf("Store holds %+v after reload, the old snapshot: %+v", store.Get(), snapshot)
+	}
+
+	// the errors of reload without 'onError' are reported to the logger
+	var warnings strings.Builder
+	loader = NewLoader(WithConfigFile(path), WithoutEnv(), WithLogger(NewWriterLogger(nil, &warnings)))
+	if w, err = loader.Watch(&config{}, nil, nil); err != nil {
+		t.Fatalf("Watch returned error: %v", err)
+	}
+	w.Close()
+	if err = os.WriteFile(path, []byte("[peers\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w.reload()
+	if !strings.Contains(warnings.String(), "Config is not reloaded") {
+		t.Errorf("Logger got warnings: %q", warnings.String())
+	}
+}
+
+func TestFindConfigFiles(t *testing.T) {
+	dir := t.TempDir()
+	systemDir, userDir, emptyDir := filepath.Join(dir, "etc"), filepath.Join(dir, "home"), filepath.Join(dir, "empty")
+	files := map[string]string{systemDir: "port = 8080\n", userDir: "name = \"" + userDir + "\"\nport = 1\n"}
+	for d, content := range files {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(d, "app.toml"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func(dirs []string, policy SearchPolicy) { ConfigSearchDirs, ConfigSearchPolicy = dirs, policy }(ConfigSearchDirs, ConfigSearchPolicy)
+	t.Setenv("TEST_USER_DIR", userDir)
+	ConfigSearchDirs = []string{systemDir, emptyDir, "$TEST_USER_DIR"}
+
+	type config struct {
+		Name string
+		Port int
+	}
+	ConfigSearchPolicy = SearchMergeAll
+	if files := FindConfigFiles("app.toml"); len(files) != 2 || filepath.Dir(files[1]) != userDir {
+		t.Errorf("FindConfigFiles returned %v; expected files from %v and %v", files, systemDir, userDir)
+	}
+	var cfg config
+	if err := LoadConfig(&cfg, "app.toml", "", nil, false); err != nil || cfg != (config{userDir, 1}) {
+		t.Errorf("LoadConfig with SearchMergeAll returned %+v, %v; expected: {%v 1}", cfg, err, userDir)
+	}
+
+	ConfigSearchPolicy = SearchFirstFound
+	if files := FindConfigFiles("app.toml"); len(files) != 1 || filepath.Dir(files[0]) != userDir {
+		t.Errorf("FindConfigFiles returned %v; expected file from %v", files, userDir)
+	}
+	ConfigSearchDirs = []string{systemDir, emptyDir}
+	cfg = config{}
+	if err := LoadConfig(&cfg, "app.toml", "", nil, false); err != nil || cfg != (config{"", 8080}) {
+		t.Errorf("LoadConfig with SearchFirstFound returned %+v, %v; expected: { 8080}", cfg, err)
+	}
+}
+
+func TestValidateStruct(t *testing.T) {
+	type server struct {
+		Host string `validate:"required"`
+	}
+	type config struct {
+		Port    int      `validate:"required,min=1,max=65535"`
+		Mode    string   `validate:"oneof=dev|prod"`
+		Name    string   `validate:"pattern=^[a-z]{1,8}$"`
+		Tags    []string `validate:"max=2,oneof=a|b"`
+		Ratio   *float64 `validate:"required,min=0.5"`
+		Servers []server
+	}
+	ratio, lowRatio := 0.75, 0.25
+	type test struct {
+		in     config
+		fields []string // paths of invalid fields expected
+	}
+	tests := [...]test{
+		{config{8080, "dev", "app", []string{"a"}, &ratio, []server{{"localhost"}}}, nil},
+		{config{0, "test", "App", []string{"a", "c", "b"}, nil, []server{{"localhost"}, {}}},
+			[]string{"Port", "Mode", "Name", "Tags", "Tags[1]", "Ratio", "Servers[1].Host"}},
+		{config{65536, "prod", "app", nil, &lowRatio, nil}, []string{"Port", "Ratio"}},
+	}
+	for i, tt := range tests {
+		var fields []string
+		err := ValidateStruct(&tt.in)
+		if errs, ok := err.(ValidationErrors); ok {
+			for _, fe := range errs {
+				fields = append(fields, fe.Field)
+			}
+		} else if err != nil {
+			t.Errorf("Test %v: ValidateStruct returned unexpected error: %v", i, err)
+		}
+		if fmt.Sprint(fields) != fmt.Sprint(tt.fields) {
+			t.Errorf("Test %v: ValidateStruct reported fields %v; expected: %v\n%v", i, fields, tt.fields, err)
+		}
+	}
+	var cfg config
+	if _, err := ParseMapToStruct(map[string]interface{}{"port": 70000}, &cfg); err == nil {
+		t.Errorf("ParseMapToStruct returned nil; expected: validation error")
+	}
+}
+
+func TestSetDefaults(t *testing.T) {
+	type dbConfig struct {
+		Host string `default:"localhost"`
+	}
+	type config struct {
+		Port    int           `default:"8080"`
+		Timeout time.Duration `default:"5s"`
+		Tags    []string      `default:"a, b"`
+		Level   *int          `default:"3"`
+		Name    string        `default:"app"`
+		DB      dbConfig
+		Backup  *dbConfig
+		Next    *config
+	}
+	cfg := config{Name: "preset"}
+	if err := SetDefaults(&cfg); err != nil {
+		t.Fatalf("SetDefaults returned error: %v", err)
+	}
+	if cfg.Port != 8080 || cfg.Timeout != 5*time.Second || fmt.Sprint(cfg.Tags) != "[a b]" ||
+		cfg.Level == nil || *cfg.Level != 3 || cfg.Name != "preset" || cfg.DB.Host != "localhost" ||
+		cfg.Backup == nil || cfg.Backup.Host != "localhost" || cfg.Next != nil {
+		t.Errorf("SetDefaults set config to %+v", cfg)
+	}
+
+	cfg = config{}
+	if _, err := ParseMapToStruct(map[string]interface{}{"port": "9090", "backup": map[string]interface{}{"host": "db"}}, &cfg); err != nil {
+		t.Fatalf("ParseMapToStruct returned error: %v", err)
+	}
+	if cfg.Port != 9090 || cfg.Name != "app" || cfg.Backup.Host != "db" {
+		t.Errorf("ParseMapToStruct set config to %+v", cfg)
+	}
+
+	var invalid struct {
+		Port int `default:"error"`
+	}
+	if err := SetDefaults(&invalid); err == nil {
+		t.Errorf("SetDefaults returned nil; expected: error")
+	}
+}
+
+func TestLoadConfigWithProvenance(t *testing.T) {
+	type config struct {
+		Name    string `default:"app"`
+		Port    int
+		Verbose bool
+		Mode    string
+		DB      struct {
+			Host string
+			User string
+		}
+	}
+	path := filepath.Join(t.TempDir(), "config.toml")
+	content := "port = 80\nmode = \"dev\"\n\n[db]\n  host = \"localhost\"\n  user = \"\"\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(prefix string) { ConfigEnvPrefix = prefix }(ConfigEnvPrefix)
+	ConfigEnvPrefix = "PROVTEST"
+	t.Setenv("PROVTEST_MODE", "prod")
+
+	var cfg config
+	prov, err := LoadConfigWithProvenance(&cfg, "", path, []string{"--verbose"}, false)
+	if err != nil {
+		t.Fatalf("LoadConfigWithProvenance returned error: %v", err)
+	}
+	type test struct {
+		field    string
+		source   ConfigSource
+		location string
+		value    interface{}
+	}
+	tests := [...]test{
+		{"Name", SourceDefault, "", "app"},
+		{"Port", SourceFile, path + ":1:1", 80},
+		{"Mode", SourceEnv, "PROVTEST_MODE", "prod"},
+		{"DB.Host", SourceFile, path + ":5:3", "localhost"},
+		{"DB.User", SourceFile, path + ":6:3", ""},
+		{"Verbose", SourceCmdLine, "--verbose", true},
+	}
+	for _, tt := range tests {
+		o := prov[tt.field]
+		if o == nil || o.Source != tt.source || o.Location() != tt.location || o.Value != tt.value {
+			t.Errorf("Provenance of '%v' is %+v; expected: %v %v %v", tt.field, o, tt.source, tt.location, tt.value)
+		}
+	}
+	var sb strings.Builder
+	if err = PrintProvenance(&sb, prov); err != nil || !strings.Contains(sb.String(), "DB.Host") {
+		t.Errorf("PrintProvenance printed:\n%v", sb.String())
+	}
+}
+
+func TestLoadConfigIncludes(t *testing.T) {
+	type config struct {
+		Name  string
+		Port  int
+		Level string
+		Mode  string
+	}
+	dir := t.TempDir()
+	files := map[string]string{
+		"config.toml":                "name = \"main\"\nport = 1\ninclude = [\"conf/*.toml\"]\n",
+		"conf/a.toml":                "port = 2\nlevel = \"a\"\n",
+		"conf/b.toml":                "level = \"b\"\n",
+		"config.toml.d/10-mode.toml": "mode = \"drop-in\"\nport = 3\n",
+		"config.toml.d/20-mode.toml": "mode = \"last\"\n",
+		"config.toml.d/ignored.json": "{\"mode\": \"json\"}",
+		"cycle.toml":                 "name = \"cycle\"\ninclude = \"cycle2.toml\"\n",
+		"cycle2.toml":                "include = \"cycle.toml\"\n",
+		"absent.toml.d/10-port.toml": "port = 5\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func(prefix string) { ConfigEnvPrefix = prefix }(ConfigEnvPrefix)
+	ConfigEnvPrefix = "-"
+
+	var cfg config
+	if err := LoadConfig(&cfg, "", filepath.Join(dir, "config.toml"), nil, false); err != nil {
+		t.Fatalf("LoadConfig returned error: %v", err)
+	}
+	expected := config{Name: "main", Port: 3, Level: "b", Mode: "last"}
+	if cfg != expected {
+		t.Errorf("LoadConfig loaded %+v; expected: %+v", cfg, expected)
+	}
+
+	cfg = config{}
+	err := LoadConfig(&cfg, "", filepath.Join(dir, "cycle.toml"), nil, false)
+	if err == nil || !strings.Contains(err.Error(), "include cycle") || !strings.Contains(err.Error(), "included from") {
+		t.Errorf("LoadConfig of include cycle returned error: %v", err)
+	}
+	if cfg.Name != "cycle" {
+		t.Errorf("LoadConfig of include cycle loaded %+v", cfg)
+	}
+
+	cfg = config{}
+	if err = LoadConfig(&cfg, "", filepath.Join(dir, "absent.toml"), nil, false); err != nil || cfg.Port != 5 {
+		t.Errorf("LoadConfig of missing file with drop-ins loaded %+v, %v; expected port: 5", cfg, err)
+	}
+}
+
+func TestResolveConfigValue(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(secret, []byte("s3cr3t\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	vars := map[string]string{"USER": "admin", "EMPTY": "", "DIR": filepath.Dir(secret)}
+	lookup := func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+	type test struct {
+		value    string
+		expected string
+		resolved bool
+		isErr    bool
+	}
+	tests := [...]test{
+		{"plain", "plain", false, false},
+		{"@home", "@home", false, false},
+		{"${USER}@host", "admin@host", true, false},
+		{"${MISSING:-guest}", "guest", true, false},
+		{"${EMPTY:-none}", "none", true, false},
+		{"$$HOME", "$HOME", true, false},
+		{"cost $5", "cost $5", false, false},
+		{"file:" + secret, "s3cr3t", true, false},
+		{"@" + secret, "s3cr3t", true, false},
+		{"file:${DIR}/secret", "s3cr3t", true, false},
+		{"${MISSING}", "${MISSING}", false, true},
+		{"${USER", "${USER", false, true},
+		{"file:/nonexistent/secret", "file:/nonexistent/secret", false, true},
+	}
+	for _, tt := range tests {
+		value, resolved, err := ResolveConfigValue(tt.value, lookup)
+		if value != tt.expected || resolved != tt.resolved || (err != nil) != tt.isErr {
+			t.Errorf("ResolveConfigValue(%q) = %q, %v, %v; expected: %q, %v, error %v",
+				tt.value, value, resolved, err, tt.expected, tt.resolved, tt.isErr)
+		}
+	}
+
+	type config struct {
+		User string
+		DB   struct {
+			Password string
+		}
+		Hosts []string
+	}
+	path := filepath.Join(t.TempDir(), "config.toml")
+	content := fmt.Sprintf("user = \"${RESOLVETEST_USER:-guest}\"\nhosts = [\"a\", \"${RESOLVETEST_HOST}\"]\n\n[db]\npassword = \"file:%v\"\n", secret)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(prefix string) { ConfigEnvPrefix = prefix }(ConfigEnvPrefix)
+	ConfigEnvPrefix = "-"
+	t.Setenv("RESOLVETEST_HOST", "b")
+	var cfg config
+	if err := LoadConfig(&cfg, "", path, nil, false); err != nil || cfg.User != "${RESOLVETEST_USER:-guest}" {
+		t.Errorf("LoadConfig resolved the references by default: %+v, %v", cfg, err)
+	}
+	defer func(resolve bool) { ConfigResolveReferences = resolve }(ConfigResolveReferences)
+	ConfigResolveReferences = true
+	cfg = config{}
+	prov, err := LoadConfigWithProvenance(&cfg, "", path, nil, false)
+	if err != nil {
+		t.Fatalf("LoadConfigWithProvenance returned error: %v", err)
+	}
+	if cfg.User != "guest" || cfg.DB.Password != "s3cr3t" || len(cfg.Hosts) != 2 || cfg.Hosts[1] != "b" {
+		t.Errorf("LoadConfig loaded %+v", cfg)
+	}
+	var sb strings.Builder
+	PrintProvenance(&sb, prov)
+	if strings.Contains(sb.String(), "s3cr3t") || !prov["DB.Password"].Redacted {
+		t.Errorf("PrintProvenance printed the secret:\n%v", sb.String())
+	}
+}
+
+type testLogger struct {
+	infos []string
+	warns []string
+}
+
+func (l *testLogger) Infof(format string, args ...interface{}) {
+	l.infos = append(l.infos, fmt.Sprintf(format, args...))
+}
+
+func (l *testLogger) Warnf(format string, args ...interface{}) {
+	l.warns = append(l.warns, fmt.Sprintf(format, args...))
+}
+
+func TestLoader(t *testing.T) {
+	type config struct {
+		Name string
+		Port int
+		Mode string
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "app.conf"), []byte("NAME=home\nPORT=1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("LOADERTEST_MODE", "env")
+	logger := &testLogger{}
+	loader := NewLoader(
+		WithHomeConfig("app.conf"),
+		WithSearchDirs(dir),
+		WithDecoder("conf", decodeDotEnv),
+		WithEnvPrefix("LOADERTEST"),
+		WithCmdLine([]string{"--port", "2"}),
+		WithLogger(logger),
+	)
+	var cfg config
+	if err := loader.Load(&cfg); err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	expected := config{Name: "home", Port: 2, Mode: "env"}
+	if cfg != expected {
+		t.Errorf("Load loaded %+v; expected: %+v", cfg, expected)
+	}
+	infos := strings.Join(logger.infos, "\n")
+	for _, s := range []string{"Loading config from", "LOADERTEST_MODE = env", "--port = 2"} {
+		if !strings.Contains(infos, s) {
+			t.Errorf("Logger messages do not contain '%v':\n%v", s, infos)
+		}
+	}
+	if _, err := GetConfigDecoder("app.conf", "conf"); err == nil {
+		t.Errorf("WithDecoder registered the decoder globally")
+	}
+
+	missing := filepath.Join(dir, "missing.toml")
+	logger = &testLogger{}
+	if err := NewLoader(WithConfigFile(missing), WithoutEnv(), WithLogger(logger)).Load(&cfg); err != nil {
+		t.Errorf("Load of missing file returned error: %v", err)
+	}
+	if len(logger.warns) != 1 || !strings.Contains(logger.warns[0], "not found") {
+		t.Errorf("Logger warnings: %v", logger.warns)
+	}
+	if err := NewLoader(WithConfigFile(missing), WithoutEnv(), WithStrict(true)).Load(&cfg); err == nil {
+		t.Errorf("Strict Load of missing file returned no error")
+	}
+
+	for _, cmdLine := range [][]string{{"--help"}, {"--completion", "bash"}} {
+		var sb strings.Builder
+		err := NewLoader(WithCmdLine(cmdLine), WithoutEnv(), WithOutput(&sb)).Load(&cfg)
+		if err != ErrHelp || !strings.Contains(sb.String(), "--port") {
+			t.Errorf("Load with %v returned %v and printed:\n%v", cmdLine, err, sb.String())
+		}
+	}
+}
+
+func TestLoaderLoadedFiles(t *testing.T) {
+	type config struct {
+		Name string
+		Port int
+	}
+	dir := t.TempDir()
+	files := map[string]string{
+		"etc/app.toml":                 "name = \"etc\"\ninclude = \"common.toml\"\n",
+		"etc/common.toml":              "port = 1\n",
+		"etc/app.toml.d/10-bad.toml":   "port = \"broken\n",
+		"home/app.toml":                "name = \"home\"\n",
+		"home/app.toml.d/10-port.toml": "port = 2\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var loaded []string
+	var cfg config
+	err := NewLoader(
+		WithHomeConfig("app.toml"),
+		WithSearchDirs(filepath.Join(dir, "etc"), filepath.Join(dir, "home")),
+		WithSearchPolicy(SearchMergeAll),
+		WithConfigFile(filepath.Join(dir, "missing.toml")),
+		WithoutEnv(),
+		WithLoadedFilesFunc(func(paths []string) { loaded = paths }),
+	).Load(&cfg)
+	if err == nil || !strings.Contains(err.Error(), "10-bad.toml") {
+		t.Errorf("Load returned error: %v", err)
+	}
+	var expected []string
+	for _, name := range []string{"etc/app.toml", "etc/common.toml", "home/app.toml", "home/app.toml.d/10-port.toml"} {
+		expected = append(expected, filepath.Join(dir, name))
+	}
+	if !reflect.DeepEqual(loaded, expected) {
+		t.Errorf("Loaded files are %v; expected: %v", loaded, expected)
+	}
+	if cfg != (config{"home", 2}) {
+		t.Errorf("Load loaded %+v", cfg)
+	}
+}
+
+func TestConfigError(t *testing.T) {
+	type config struct {
+		Port  int    `validate:"max=1000"`
+		Mode  string `validate:"oneof=dev|prod"`
+		Level int
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.toml")
+	if err := os.WriteFile(path, []byte("# config\nport = 8080\nmode = \"test\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ERRTEST_LEVEL", "high")
+	var cfg config
+	err := NewLoader(WithConfigFile(path), WithEnvPrefix("ERRTEST"), WithCmdLine([]string{"--unknown"})).Load(&cfg)
+	var ce *ConfigError
+	if !errors.As(err, &ce) {
+		t.Fatalf("Load returned error of type %T: %v", err, err)
+	}
+	for _, kind := range []error{ErrConfigValue, ErrConfigArgument, ErrConfigValidation} {
+		if !errors.Is(err, kind) {
+			t.Errorf("Load error is not '%v':\n%v", kind, err)
+		}
+	}
+	if errors.Is(err, ErrConfigSyntax) {
+		t.Errorf("Load error is '%v':\n%v", ErrConfigSyntax, err)
+	}
+	var fieldErr *FieldError
+	if !errors.As(err, &fieldErr) || fieldErr.Field != "Port" {
+		t.Errorf("Load error has no FieldError of 'Port': %v", fieldErr)
+	}
+	type test struct {
+		field    string
+		kind     error
+		location string
+	}
+	tests := [...]test{
+		{"Port", ErrConfigValidation, path + ":2:1"},
+		{"Mode", ErrConfigValidation, path + ":3:1"},
+		{"Level", ErrConfigValue, "ERRTEST_LEVEL"},
+		{"", ErrConfigArgument, "--unknown"},
+	}
+	if len(ce.Entries) != len(tests) {
+		t.Fatalf("Load returned %v errors; expected %v:\n%v", len(ce.Entries), len(tests), err)
+	}
+	for _, tt := range tests {
+		found := false
+		for _, e := range ce.Entries {
+			found = found || (e.Field == tt.field && e.Kind == tt.kind && e.Location() == tt.location)
+		}
+		if !found {
+			t.Errorf("Error of field '%v' at '%v' is not found:\n%v", tt.field, tt.location, err)
+		}
+	}
+	if !strings.Contains(ce.Render(), "2 | port = 8080\n    | ^\n") {
+		t.Errorf("Render returned:\n%v", ce.Render())
+	}
+
+	if err := os.WriteFile(path, []byte("port = 1\nmode = dev\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg = config{}
+	err = NewLoader(WithConfigFile(path), WithoutEnv()).Load(&cfg)
+	if !errors.As(err, &ce) || !errors.Is(err, ErrConfigSyntax) || ce.Entries[0].Line != 2 {
+		t.Fatalf("Load of invalid TOML returned error: %v", err)
+	}
+	if err = NewLoader(WithConfigFile(filepath.Join(dir, "none.toml")), WithStrict(true)).Load(&cfg); !errors.Is(err, ErrConfigNotFound) {
+		t.Errorf("Strict Load of missing file returned error: %v", err)
+	}
+}
+
+func TestUnknownKeys(t *testing.T) {
+	type config struct {
+		Port    int
+		Name    string `toml:"app_name" yaml:"app_name"`
+		Servers []struct {
+			Host string
+		}
+		DB struct {
+			Host string
+		}
+	}
+	dir := t.TempDir()
+	files := map[string]string{
+		"config.toml": "include = []\nprot = 8080\napp_nam = \"x\"\n\n[db]\nhots = \"h\"\n\n[[servers]]\nhost = \"a\"\n\n[cache]\nsize = 1\n",
+		"config.yaml": "port: 1\nservers:\n  - hst: a\ndb:\n  host: h\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("UNKTEST_PORTT", "1")
+
+	type test struct {
+		file     string
+		expected []string
+	}
+	tests := [...]test{
+		{"config.toml", []string{
+			"config.toml:2:1: unknown key 'prot', did you mean 'port'?",
+			"config.toml:3:1: unknown key 'app_nam', did you mean 'app_name'?",
+			"config.toml:6:1: unknown key 'db.hots', did you mean 'db.host'?",
+			"config.toml:11:1: unknown key 'cache'\n",
+			"UNKTEST_PORTT: unknown environment variable 'UNKTEST_PORTT', did you mean 'UNKTEST_PORT'?",
+		}},
+		{"config.yaml", []string{
+			"config.yaml:3:5: unknown key 'servers.hst', did you mean 'servers.host'?",
+		}},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.file)
+		logger := &testLogger{}
+		var cfg config
+		err := NewLoader(WithConfigFile(path), WithEnvPrefix("UNKTEST"), WithUnknownKeys(UnknownKeysWarn),
+			WithLogger(logger)).Load(&cfg)
+		if err != nil {
+			t.Errorf("Load of '%v' with UnknownKeysWarn returned error: %v", tt.file, err)
+		}
+		warnings := strings.Join(logger.warns, "\n")
+		for _, s := range tt.expected {
+			if !strings.Contains(warnings, s) {
+				t.Errorf("Load of '%v' warnings do not contain \"%v\":\n%v", tt.file, s, warnings)
+			}
+		}
+
+		err = NewLoader(WithConfigFile(path), WithEnvPrefix("UNKTEST"), WithStrict(true)).Load(&cfg)
+		var ce *ConfigError
+		if !errors.As(err, &ce) || !errors.Is(err, ErrConfigUnknownKey) || len(ce.Entries) != len(tt.expected) {
+			t.Errorf("Strict Load of '%v' returned error:\n%v", tt.file, err)
+		}
+		if tt.file == "config.toml" {
+			t.Setenv("UNKTEST_PORTT", "")
+			os.Unsetenv("UNKTEST_PORTT")
+		}
+	}
+
+	var cfg config
+	_, err := ParseCmdLineToStruct([]string{"--prot", "1"}, &cfg)
+	if err == nil || !strings.Contains(err.Error(), "did you mean '--port'?") {
+		t.Errorf("ParseCmdLineToStruct returned error: %v", err)
+	}
+}
+
+func TestConfigProfiles(t *testing.T) {
+	type config struct {
+		Port int
+		Mode string
+		Log  string
+		DB   struct {
+			Host string
+		}
+	}
+	path := filepath.Join(t.TempDir(), "config.toml")
+	content := `port = 1
+mode = "base"
+log = "info"
+
+[db]
+host = "localhost"
+
+[profile.common]
+mode = "common"
+log = "warn"
+
+[profile.prod]
+inherits = "common"
+port = 3
+
+[profile.prod.db]
+host = "db.prod"
+
+[profile.dev]
+inherits = ["common"]
+log = "debug"
+
+[profile.loop]
+inherits = "loop2"
+
+[profile.loop2]
+inherits = "loop"
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PROFTEST_PROFILE", "dev")
+	type test struct {
+		options  []LoaderOption
+		expected config
+		isErr    bool
+	}
+	var base, prod, dev config
+	base.Port, base.Mode, base.Log, base.DB.Host = 1, "base", "info", "localhost"
+	prod.Port, prod.Mode, prod.Log, prod.DB.Host = 3, "common", "warn", "db.prod"
+	dev.Port, dev.Mode, dev.Log, dev.DB.Host = 1, "common", "debug", "localhost"
+	tests := [...]test{
+		{[]LoaderOption{WithoutEnv()}, base, false},
+		{[]LoaderOption{WithoutEnv(), WithProfile("prod")}, prod, false},
+		{[]LoaderOption{WithEnvPrefix("PROFTEST"), WithProfile("prod")}, dev, false},
+		{[]LoaderOption{WithEnvPrefix("PROFTEST"), WithCmdLine([]string{"--profile", "prod"})}, prod, false},
+		{[]LoaderOption{WithoutEnv(), WithCmdLine([]string{"--profile=dev", "--port=5"})}, config{}, false},
+		{[]LoaderOption{WithoutEnv(), WithProfile("loop")}, config{}, true},
+		{[]LoaderOption{WithoutEnv(), WithProfile("qa")}, config{}, true},
+	}
+	tests[4].expected = dev
+	tests[4].expected.Port = 5
+	for i, tt := range tests {
+		var cfg config
+		options := append([]LoaderOption{WithConfigFile(path), WithStrict(true)}, tt.options...)
+		prov, err := NewLoader(options...).LoadWithProvenance(&cfg)
+		if (err != nil) != tt.isErr {
+			t.Errorf("Test %v: Load returned error: %v", i, err)
+			continue
+		}
+		if !tt.isErr && cfg != tt.expected {
+			t.Errorf("Test %v: Load loaded %+v; expected: %+v", i, cfg, tt.expected)
+		}
+		if i == 1 {
+			if o := prov["DB.Host"]; o.Profile != "prod" || o.Name != "profile.prod.db.host" || o.Line != 17 {
+				t.Errorf("Provenance of 'DB.Host' is %+v", o)
+			}
+			if o := prov["Mode"]; o.Profile != "common" {
+				t.Errorf("Provenance of 'Mode' is %+v", o)
+			}
+		}
+	}
+}
+
+func TestSaveConfig(t *testing.T) {
+	type config struct {
+		Name    string
+		Port    int `default:"8080"`
+		Tags    []string
+		Timeout time.Duration `default:"5s"`
+		DB      struct {
+			Host string
+			User string `toml:"user_name"`
+		}
+		Log struct {
+			Level string
+		}
+	}
+	path := filepath.Join(t.TempDir(), "config.toml")
+	content := "# Application config\n" +
+		"name = \"app\" # the name\n" +
+		"tags = [\n  \"a\", # first\n  \"b\",\n]\n" +
+		"\n" +
+		"[db]\n" +
+		"host = 'localhost'\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var cfg config
+	if err := LoadConfig(&cfg, "", path, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Name = "new \"app\""
+	cfg.Tags = []string{"c"}
+	cfg.DB.User = "admin"
+	cfg.Log.Level = "debug"
+	if err := SaveConfig(path, &cfg); err != nil {
+		t.Fatalf("SaveConfig returned error: %v", err)
+	}
+	expected := "# Application config\n" +
+		"name = \"new \\\"app\\\"\" # the name\n" +
+		"tags = [\"c\"]\n" +
+		"\n" +
+		"[db]\n" +
+		"host = 'localhost'\n" +
+		"user_name = \"admin\"\n" +
+		"\n" +
+		"[Log]\n" +
+		"Level = \"debug\"\n"
+	data, _ := os.ReadFile(path)
+	if string(data) != expected {
+		t.Errorf("SaveConfig wrote:\n%v\nexpected:\n%v", string(data), expected)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("SaveConfig changed file mode: %v, %v", info.Mode(), err)
+	}
+
+	var loaded config
+	if err := LoadConfig(&loaded, "", path, nil, false); err != nil || fmt.Sprint(loaded) != fmt.Sprint(cfg) {
+		t.Errorf("LoadConfig returned %+v, %v; expected: %+v", loaded, err, cfg)
+	}
+	if err := UpdateConfigFile(path, map[string]interface{}{"port": 9090, "db": map[string]interface{}{"host": "db"}}); err != nil {
+		t.Fatalf("UpdateConfigFile returned error: %v", err)
+	}
+	data, _ = os.ReadFile(path)
+	if !strings.Contains(string(data), "tags = [\"c\"]\nport = 9090\n\n[db]\nhost = \"db\"\n") {
+		t.Errorf("UpdateConfigFile wrote:\n%v", string(data))
+	}
+
+	type server struct {
+		Name string
+		Port int
+	}
+	type tablesConfig struct {
+		Title   string
+		Labels  map[string]string
+		Servers []server
+		Devices map[string]server
+		Secret  string   `toml:"-"`
+		Token   string   `yaml:"-"`
+		Files   []string `positional:""`
+		Run     *struct {
+			Force bool
+		} `command:"run"`
+	}
+	content = "title = \"x\"\n" +
+		"\n" +
+		"# labels\n" +
+		"[Labels]\n" +
+		"env = \"dev\"\n" +
+		"old = \"1\"\n" +
+		"\n" +
+		"[[servers]]\n" +
+		"name = \"a\"\n" +
+		"port = 1\n" +
+		"\n" +
+		"[[servers]]\n" +
+		"name = \"b\"\n" +
+		"port = 2\n" +
+		"\n" +
+		"# the end\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var tables tablesConfig
+	if err := NewLoader(WithConfigFile(path), WithoutEnv(), WithStrict(true)).Load(&tables); err != nil {
+		t.Fatal(err)
+	}
+	tables.Labels = map[string]string{"env": "prod", "new": "2"}
+	tables.Servers = []server{{"c", 3}}
+	tables.Devices = map[string]server{"camera": {"cam", 554}}
+	tables.Secret = "secret"
+	tables.Token = "token"
+	tables.Files = []string{"file"}
+	if err := SaveConfig(path, &tables); err != nil {
+		t.Fatalf("SaveConfig returned error: %v", err)
+	}
+	expected = "title = \"x\"\n" +
+		"\n" +
+		"# labels\n" +
+		"[Labels]\n" +
+		"env = \"prod\"\n" +
+		"new = \"2\"\n" +
+		"\n" +
+		"[[servers]]\n" +
+		"name = \"c\"\n" +
+		"port = 3\n" +
+		"\n" +
+		"# the end\n" +
+		"\n" +
+		"[Devices.camera]\n" +
+		"Name = \"cam\"\n" +
+		"Port = 554\n"
+	data, _ = os.ReadFile(path)
+	if string(data) != expected {
+		t.Errorf("SaveConfig wrote:\n%v\nexpected:\n%v", string(data), expected)
+	}
+	var reloaded tablesConfig
+	if err := NewLoader(WithConfigFile(path), WithoutEnv(), WithStrict(true)).Load(&reloaded); err != nil {
+		t.Fatalf("Load of saved config returned error: %v", err)
+	}
+	tables.Secret, tables.Token, tables.Files = "", "", nil
+	if !reflect.DeepEqual(reloaded, tables) {
+		t.Errorf("Load of saved config returned %+v; expected: %+v", reloaded, tables)
+	}
+
+	// the tables of map entries are updated in place keeping the comments and the order of keys
+	var devices struct {
+		Device map[string]server
+	}
+	content = "[device.x]\n" +
+		"# the port of device\n" +
+		"port = 1 # primary\n" +
+		"name = \"a\"\n" +
+		"\n" +
+		"[device.y]\n" +
+		"name = \"b\"\n" +
+		"port = 2\n" +
+		"\n" +
+		"# the end\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewLoader(WithConfigFile(path), WithoutEnv(), WithStrict(true)).Load(&devices); err != nil {
+		t.Fatal(err)
+	}
+	devices.Device["x"] = server{"a", 10}
+	delete(devices.Device, "y")
+	devices.Device["z"] = server{"c", 3}
+	if err := SaveConfig(path, &devices); err != nil {
+		t.Fatalf("SaveConfig returned error: %v", err)
+	}
+	expected = "[device.x]\n" +
+		"# the port of device\n" +
+		"port = 10 # primary\n" +
+		"name = \"a\"\n" +
+		"\n" +
+		"[device.z]\n" +
+		"name = \"c\"\n" +
+		"port = 3\n" +
+		"\n" +
+		"# the end\n"
+	data, _ = os.ReadFile(path)
+	if string(data) != expected {
+		t.Errorf("SaveConfig wrote:\n%v\nexpected:\n%v", string(data), expected)
+	}
+}
+
+func TestStore(t *testing.T) {
+	type config struct {
+		Port  int
+		Tags  []string
+		Peers map[string]string
+		DB    struct {
+			Host string
+			Port int
+		}
+	}
+	initial := &config{Port: 80, Tags: []string{"a"}, Peers: map[string]string{"x": "1"}}
+	store := NewStore(initial)
+	initial.Tags[0] = "changed"
+	if got := store.Get(); got.Tags[0] != "a" {
+		t.Errorf("Store shares the initial config: %+v", got)
+	}
+
+	var changes [][]string
+	unsubscribe := store.Subscribe(func(oldConfig, newConfig *config, changedFields []string) {
+		changes = append(changes, changedFields)
+	})
+	snapshot := store.Get()
+	store.Update(func(c *config) {
+		c.Tags[0] = "b"
+		c.Peers["y"] = "2"
+		c.DB.Host = "db"
+	})
+	if snapshot.Tags[0] != "a" || len(snapshot.Peers) != 1 || snapshot.DB.Host != "" {
+		t.Errorf("Update modified the snapshot: %+v", snapshot)
+	}
+	if got := store.Get(); got.Tags[0] != "b" || got.Peers["y"] != "2" || got.DB.Host != "db" {
+		t.Errorf("Update is not applied: %+v", got)
+	}
+	store.Update(func(c *config) {}) // no changes, no notification
+	if fmt.Sprint(changes) != "[[Tags Peers DB.Host]]" {
+		t.Errorf("Subscriber received %v", changes)
+	}
+	unsubscribe()
+	store.Set(&config{Port: 1})
+	if len(changes) != 1 {
+		t.Errorf("Unsubscribed function is called: %v", changes)
+	}
+
+	// readers must see DB.Host and DB.Port updated together
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if c := store.Get(); c.DB.Host != strconv.Itoa(c.DB.Port) {
+					t.Errorf("Half-applied config: %+v", c.DB)
+					return
+				}
+			}
+		}()
+	}
+	store.Set(&config{})
+	store.Update(func(c *config) { c.DB.Host = "0" })
+	for i := 1; i <= 1000; i++ {
+		store.Update(func(c *config) {
+			c.DB.Port++
+			c.DB.Host = strconv.Itoa(c.DB.Port)
+		})
+	}
+	close(stop)
+	wg.Wait()
+	if got := store.Get().DB.Port; got != 1000 {
+		t.Errorf("Update lost changes: DB.Port = %v", got)
+	}
+
+	// the notifications of concurrent updates are delivered in the order of changes
+	last := store.Get().DB.Port
+	unsubscribe = store.Subscribe(func(oldConfig, newConfig *config, changedFields []string) {
+		if oldConfig.DB.Port != last || newConfig.DB.Port != last+1 {
+			t.Errorf("Notification out of order: %v -> %v after %v", oldConfig.DB.Port, newConfig.DB.Port, last)
+		}
+		last = newConfig.DB.Port
+	})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 250; j++ {
+				store.Update(func(c *config) { c.DB.Port++ })
+			}
+		}()
+	}
+	wg.Wait()
+	unsubscribe()
+	if last != 2000 {
+		t.Errorf("Subscriber received the last port %v", last)
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.toml")
+	if err := os.WriteFile(path, []byte("port = 8080\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	store = NewStore(&config{Tags: []string{"default"}})
+	loader := NewLoader(WithConfigFile(path), WithoutEnv())
+	if err := store.Load(loader); err != nil {
+		t.Fatal(err)
+	}
+	if got := store.Get(); got.Port != 8080 || fmt.Sprint(got.Tags) != "[default]" {
+		t.Errorf("Store.Load loaded %+v", got)
+	}
+	if err := os.WriteFile(path, []byte("port = 'x'\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Load(loader); err == nil || store.Get().Port != 8080 {
+		t.Errorf("Store.Load of broken config returned %v, config: %+v", err, store.Get())
+	}
+}
+
+func TestLoadConfigSources(t *testing.T) {
+	type config struct {
+		Name string
+		Port int
+		Log  struct {
+			Level string
+		}
+		Tags []string
+	}
+	embedded := fstest.MapFS{
+		"defaults/config.toml":             {Data: []byte("name = 'default'\nport = 80\ninclude = 'log.toml'\n")},
+		"defaults/log.toml":                {Data: []byte("[log]\nlevel = 'info'\n")},
+		"defaults/config.toml.d/10-x.toml": {Data: []byte("tags = ['embedded']\n")},
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.toml")
+	if err := os.WriteFile(path, []byte("port = 8080\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var cfg config
+	prov, err := NewLoader(WithDefaultsFS(embedded, "defaults/config.toml"), WithConfigFile(path), WithoutEnv(),
+		WithReader("override.yaml", strings.NewReader("log:\n  level: debug\n"))).LoadWithProvenance(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Name != "default" || cfg.Port != 8080 || cfg.Log.Level != "debug" || fmt.Sprint(cfg.Tags) != "[embedded]" {
+		t.Errorf("Loader loaded %+v", cfg)
+	}
+	if o := prov["Name"]; o == nil || o.File != "defaults/config.toml" || o.Line != 1 {
+		t.Errorf("Provenance of embedded value: %+v", o)
+	}
+	if o := prov["Log.Level"]; o == nil || o.File != "override.yaml" || o.Line != 2 {
+		t.Errorf("Provenance of reader value: %+v", o)
+	}
+
+	// the reader is read once, the data is reused by next loading
+	reader := NewLoader(WithoutEnv(), WithReader("config.json", strings.NewReader(`{"port": 1}`)))
+	for i := 0; i < 2; i++ {
+		cfg = config{}
+		if err := reader.Load(&cfg); err != nil || cfg.Port != 1 {
+			t.Errorf("Loading #%v from reader: %+v, %v", i, cfg, err)
+		}
+	}
+
+	var ce *ConfigError
+	err = NewLoader(WithoutEnv(), WithReader("bad.toml", strings.NewReader("port = 80x\n"))).Load(&cfg)
+	if !errors.As(err, &ce) || !errors.Is(err, ErrConfigSyntax) || ce.Entries[0].Location() != "bad.toml:1:10" {
+		t.Errorf("Loading broken config from reader returned %v", err)
+	}
+
+	saved := stdinSource
+	defer func() { stdinSource = saved }()
+	stdinSource = &readerSource{name: configStdinName, r: strings.NewReader("name = 'stdin'\n")}
+	cfg = config{}
+	if err := LoadConfig(&cfg, "", ConfigStdinPath, nil, false); err != nil || cfg.Name != "stdin" {
+		t.Errorf("Loading from stdin: %+v, %v", cfg, err)
+	}
+}
+
+func TestCmdLineCommands(t *testing.T) {
+	type remoteAdd struct {
+		URL string
+	}
+	type config struct {
+		Verbose bool `short:"v" help:"Verbose output"`
+		Sync    *struct {
+			DryRun  bool `help:"Show what would be done"`
+			Workers int  `default:"4"`
+		} `command:"sync" help:"Synchronize files"`
+		Status *struct {
+			JSON bool `long:"json"`
+		} `command:"status" help:"Show status"`
+		Remote *struct {
+			Add *remoteAdd `command:"add"`
+		} `command:"remote"`
+	}
+	type test struct {
+		cmdLine []string
+		command string
+		out     string
+		exErr   bool
+	}
+	tests := [...]test{
+		{[]string{}, "", "false <nil> <nil>", false},
+		{[]string{"-v", "sync", "--dry-run"}, "sync", "true &{true 4} <nil>", false},
+		{[]string{"sync", "--workers=2", "-v"}, "sync", "true &{false 2} <nil>", false},
+		{[]string{"status", "--json"}, "status", "false <nil> &{true}", false},
+		{[]string{"remote", "add", "--url", "http://host"}, "remote add", "false <nil> <nil>", false},
+		{[]string{"--dry-run", "sync"}, "sync", "", true},
+		{[]string{"statsu"}, "", "", true},
+		{[]string{"sync", "status"}, "sync", "", true},
+	}
+	for i, tt := range tests {
+		var cfg config
+		_, err := ParseCmdLineToStruct(tt.cmdLine, &cfg)
+		out := fmt.Sprint(cfg.Verbose, " ", cfg.Sync, " ", cfg.Status)
+		if (err != nil) != tt.exErr || GetCmdLineCommand(&cfg) != tt.command || (!tt.exErr && out != tt.out) {
+			t.Errorf("Test %v: ParseCmdLineToStruct(%q) returned %v, command '%v', error: %v",
+				i, tt.cmdLine, out, GetCmdLineCommand(&cfg), err)
+		}
+	}
+	var cfg config
+	_, err := ParseCmdLineToStruct([]string{"statsu"}, &cfg)
+	if err == nil || !strings.Contains(err.Error(), "unknown command 'statsu', did you mean 'status'?") {
+		t.Errorf("ParseCmdLineToStruct(statsu) returned %v", err)
+	}
+	if _, err := ParseCmdLineToStruct([]string{"remote", "add", "--url", "x"}, &cfg); err != nil ||
+		cfg.Remote == nil || cfg.Remote.Add == nil || cfg.Remote.Add.URL != "x" {
+		t.Errorf("ParseCmdLineToStruct(remote add) returned %+v, %v", cfg.Remote, err)
+	}
+
+	// the config file may set the options of commands, the commands not selected are 'nil'
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte("[sync]\nworkers = 8\n[status]\njson = true\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg = config{}
+	if err := LoadConfig(&cfg, "", path, []string{"sync"}, false); err != nil ||
+		cfg.Sync == nil || cfg.Sync.Workers != 8 || cfg.Status != nil {
+		t.Errorf("LoadConfig(sync) returned %+v, %v", cfg, err)
+	}
+
+	cfg = config{}
+	if _, err := ParseCmdLineToStruct([]string{"--help"}, &cfg); err != ErrHelp {
+		t.Errorf("ParseCmdLineToStruct(--help) returned %v; expected: ErrHelp", err)
+	}
+	usage := GetCmdLineUsage("tool", &cfg)
+	if !strings.Contains(usage, "Usage: tool [options] <command> [command options]\n") ||
+		!strings.Contains(usage, "\nCommands:\n  sync    Synchronize files\n  status  Show status\n  remote  \n") {
+		t.Errorf("GetCmdLineUsage returned:\n%v", usage)
+	}
+	if _, err := ParseCmdLineToStruct([]string{"sync", "-h"}, &cfg); err != ErrHelp {
+		t.Errorf("ParseCmdLineToStruct(sync -h) returned %v; expected: ErrHelp", err)
+	}
+	usage = GetCmdLineUsage("tool", &cfg)
+	if !strings.HasPrefix(usage, "Usage: tool sync [options]\n\nSynchronize files\n\nOptions:\n") ||
+		!strings.Contains(usage, "--dry-run, --no-dry-run  Show what would be done\n") ||
+		!strings.Contains(usage, "\nGlobal options:\n  -v, --verbose, --no-verbose  Verbose output\n") ||
+		strings.Contains(usage, "--json") {
+		t.Errorf("GetCmdLineUsage of command returned:\n%v", usage)
+	}
+}
+
+func TestCmdLineCollections(t *testing.T) {
+	type config struct {
+		Tags   []string `long:"tag" short:"t"`
+		Ports  []int
+		Labels map[string]string
+		Limits map[string]int
+		Output string   `positional:"" help:"Output file"`
+		Inputs []string `positional:"" help:"Input files"`
+	}
+	type test struct {
+		cmdLine []string
+		out     string
+		exErr   bool
+	}
+	tests := [...]test{
+		{[]string{"--tag", "a", "--tag", "b"}, "[a b] [1] map[x:1] map[]  []", false},
+		{[]string{"--tag=a,b", "-t", "c", "--ports", "0x50,443"}, "[a b c] [80 443] map[x:1] map[]  []", false},
+		{[]string{"--labels", "k=v", "--labels", "a=b,c=d", "--limits=cpu=2"},
+			"[] [1] map[a:b c:d k:v] map[cpu:2]  []", false},
+		{[]string{"out.txt", "in1,x", "--tag=a", "in2"}, "[a] [1] map[x:1] map[] out.txt [in1,x in2]", false},
+		{[]string{"--", "-o", "-i"}, "[] [1] map[x:1] map[] -o [-i]", false},
+		{[]string{"--labels", "novalue"}, "", true},
+		{[]string{"--limits", "cpu=x"}, "", true},
+	}
+	for i, tt := range tests {
+		cfg := config{Tags: []string{}, Ports: []int{1}, Labels: map[string]string{"x": "1"}, Limits: map[string]int{}}
+		_, err := ParseCmdLineToStruct(tt.cmdLine, &cfg)
+		out := fmt.Sprint(cfg.Tags, " ", cfg.Ports, " ", cfg.Labels, " ", cfg.Limits, " ", cfg.Output, " ", cfg.Inputs)
+		if (err != nil) != tt.exErr || (!tt.exErr && out != tt.out) {
+			t.Errorf("Test %v: ParseCmdLineToStruct(%q) returned %v, error: %v; expected: %v",
+				i, tt.cmdLine, out, err, tt.out)
+		}
+	}
+	var cfg config
+	usage := GetCmdLineUsage("app", &cfg)
+	if !strings.HasPrefix(usage, "Usage: app [options] <output> [<inputs>...]\n\nArguments:\n  <output>  Output file\n") ||
+		!strings.Contains(usage, "--labels <string=string...>") || !strings.Contains(usage, "-t, --tag <string...>") {
+		t.Errorf("GetCmdLineUsage returned:\n%v", usage)
+	}
+
+	cfg = config{}
+	vars := map[string]string{"APP_TAGS": "a, b", "APP_LABELS": "env=prod,zone=eu", "APP_LIMITS": "cpu=0x10"}
+	lookup := func(name string) (string, bool) { v, ok := vars[name]; return v, ok }
+	if _, err := parseEnvToStruct(lookup, "APP", &cfg); err != nil ||
+		fmt.Sprint(cfg.Tags, cfg.Labels, cfg.Limits) != "[a b] map[env:prod zone:eu] map[cpu:16]" {
+		t.Errorf("parseEnvToStruct returned %+v, %v", cfg, err)
+	}
+}
+
+func TestWriteCompletionScript(t *testing.T) {
+	type config struct {
+		Verbose bool   `short:"v" help:"Verbose output"`
+		Mode    string `validate:"oneof=dev|prod"`
+		Out     string `path:"dir"`
+		Sync    *struct {
+			DryRun bool
+			Files  []string `positional:"" path:"file"`
+		} `command:"sync" help:"Synchronize files"`
+	}
+	expected := map[string][]string{
+		"bash": {"_my_app() {", "':sync') cmd=", "':--mode') COMPREPLY=($(compgen -W 'dev prod' -- \"$cur\")); return ;;",
+			"':--out') COMPREPLY=($(compgen -d -- \"$cur\")); return ;;",
+			"'sync') flags='--dry-run --no-dry-run --verbose --no-verbose -v --mode --out --help -h'; words=''; kind='file' ;;",
+			"complete -o filenames -F _my_app my-app\n"},
+		"zsh": {"#compdef my-app\n", "':--mode') compadd -- 'dev' 'prod'; return ;;", "':--out') _files -/; return ;;",
+			"compdef _my_app my-app\n"},
+		"fish": {"complete -c my-app -n \"_my_app_command ''\" -l verbose -s v -d 'Verbose output'\n",
+			"complete -c my-app -n \"_my_app_command ''\" -a 'sync' -d 'Synchronize files'\n",
+			"complete -c my-app -n \"_my_app_command ''\" -l mode -x -a 'dev prod'\n",
+			"complete -c my-app -n \"_my_app_command 'sync'\" -F\n"},
+	}
+	for shell, parts := range expected {
+		var sb strings.Builder
+		if err := WriteCompletionScript(&sb, shell, "my-app", &config{}); err != nil {
+			t.Fatalf("WriteCompletionScript(%v) returned error: %v", shell, err)
+		}
+		for _, part := range parts {
+			if !strings.Contains(sb.String(), part) {
+				t.Errorf("WriteCompletionScript(%v) returned:\n%v\nexpected part: %v", shell, sb.String(), part)
+			}
+		}
+	}
+	if err := WriteCompletionScript(&strings.Builder{}, "cmd", "app", &config{}); err == nil {
+		t.Errorf("WriteCompletionScript returned no error for unknown shell")
+	}
+	for _, tt := range []struct {
+		cmdLine []string
+		shell   string
+	}{{[]string{"-v", "--completion", "zsh"}, "zsh"}, {[]string{"--completion=fish"}, "fish"},
+		{[]string{"--", "--completion=fish"}, ""}, {[]string{"--verbose"}, ""}} {
+		if shell, _ := findHiddenCmdLineFlag(tt.cmdLine, "completion", reflect.ValueOf(config{})); shell != tt.shell {
+			t.Errorf("findHiddenCmdLineFlag(%q) returned '%v'; expected: '%v'", tt.cmdLine, shell, tt.shell)
+		}
+	}
+}
+
+func TestWriteDefaultConfig(t *testing.T) {
+	type config struct {
+		Name    string        `help:"Application name"`
+		Mode    string        `default:"dev" validate:"oneof=dev|prod" help:"Run mode"`
+		Port    int           `validate:"min=1,max=65535"`
+		Timeout time.Duration `default:"5s" env:"TIMEOUT"`
+		Limit   *int
+		Tags    []string `long:"tag"`
+		Secret  string   `long:"-" toml:"secret_key"`
+		Token   string   `long:"-" json:"-"`
+		DB      struct {
+			Host string `default:"localhost" help:"Database host"`
+		} `help:"Database connection"`
+	}
+	cfg := config{Port: 8080}
+	var sb strings.Builder
+	if err := writeDefaultConfig(&sb, &cfg, "APP"); err != nil {
+		t.Fatal(err)
+	}
+	expected := "# Application name\n" +
+		"# Set by environment variable APP_NAME, command line flag --name\n" +
+		"name = \"\"\n" +
+		"\n" +
+		"# Run mode\n" +
+		"# Allowed values: one of: dev, prod\n" +
+		"# Set by environment variable APP_MODE, command line flag --mode\n" +
+		"mode = \"dev\"\n" +
+		"\n" +
+		"# Allowed values: min: 1, max: 65535\n" +
+		"# Set by environment variable APP_PORT, command line flag --port\n" +
+		"port = 8080\n" +
+		"\n" +
+		"# Set by environment variable APP_TIMEOUT, command line flag --timeout\n" +
+		"timeout = \"5s\"\n" +
+		"\n" +
+		"# Set by environment variable APP_LIMIT, command line flag --limit\n" +
+		"# limit = <int>\n" +
+		"\n" +
+		"# Set by environment variable APP_TAGS, command line flag --tag\n" +
+		"tags = []\n" +
+		"\n" +
+		"# Set by environment variable APP_SECRET\n" +
+		"secret_key = \"\"\n" +
+		"\n" +
+		"# Database connection\n" +
+		"[db]\n" +
+		"\n" +
+		"# Database host\n" +
+		"# Set by environment variable APP_DB_HOST, command line flag --db.host\n" +
+		"host = \"localhost\"\n"
+	if sb.String() != expected {
+		t.Errorf("writeDefaultConfig wrote:\n%v\nexpected:\n%v", sb.String(), expected)
+	}
+	if cfg.Mode != "" {
+		t.Errorf("writeDefaultConfig modified the config: %+v", cfg)
+	}
+
+	path := filepath.Join(t.TempDir(), "conf", "config.toml")
+	if err := WriteDefaultConfigFile(path, &cfg, false); err != nil {
+		t.Fatal(err)
+	}
+	var loaded config
+	if err := NewLoader(WithConfigFile(path), WithoutEnv(), WithStrict(true)).Load(&loaded); err != nil ||
+		loaded.Port != 8080 || loaded.DB.Host != "localhost" {
+		t.Errorf("Loading default config returned %+v, %v", loaded, err)
+	}
+	if err := WriteDefaultConfigFile(path, &config{}, false); !errors.Is(err, os.ErrExist) {
+		t.Errorf("WriteDefaultConfigFile overwrote existing file, error: %v", err)
+	}
+	if err := WriteDefaultConfigFile(path, &config{}, true); err != nil {
+		t.Errorf("WriteDefaultConfigFile(overwrite) returned error: %v", err)
+	}
+	loaded = config{}
+	err := NewLoader(WithCmdLine([]string{"--write-default-config", path})).Load(&loaded)
+	if !errors.Is(err, os.ErrExist) || !strings.Contains(err.Error(), "--force") {
+		t.Errorf("Loader with --write-default-config of existing file returned %v", err)
+	}
+	if !errors.Is(err, ErrConfigArgument) {
+		t.Errorf("Loader with --write-default-config of existing file returned %v; expected: %v", err, ErrConfigArgument)
+	}
+	err = NewLoader(WithCmdLine([]string{"--write-default-config", path, "--", "--force"})).Load(&loaded)
+	if !errors.Is(err, os.ErrExist) {
+		t.Errorf("Loader with --force after '--' returned %v", err)
+	}
+	sb.Reset()
+	err = NewLoader(WithCmdLine([]string{"--force", "--write-default-config", path}), WithOutput(&sb)).Load(&loaded)
+	if err != ErrHelp || !strings.Contains(sb.String(), "is written") {
+		t.Errorf("Loader with --force returned %v and printed %q", err, sb.String())
+	}
+
+	sb.Reset()
+	if err := WriteConfigMarkdown(&sb, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(sb.String(), "| Key | Type | Default | Environment | Flag | Description |\n| --- |") ||
+		!strings.Contains(sb.String(), "| `mode` | string | `\"dev\"` | ") ||
+		!strings.Contains(sb.String(), " | `--mode` | Run mode. Allowed values: one of: dev, prod |\n") ||
+		!strings.Contains(sb.String(), "| `db.host` | string | `\"localhost\"` |") {
+		t.Errorf("WriteConfigMarkdown wrote:\n%v", sb.String())
+	}
+	sb.Reset()
+	if err := WriteConfigManPage(&sb, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(sb.String(), ".SH CONFIGURATION\n.TP\n.B name\nApplication name\n.br\nType: string. Default: \"\".\n") ||
+		!strings.Contains(sb.String(), ".TP\n.B db.host\nDatabase host\n.br\n") {
+		t.Errorf("WriteConfigManPage wrote:\n%v", sb.String())
+	}
+}
+
+func TestGetConfigJSONSchema(t *testing.T) {
+	type server struct {
+		Host string `validate:"required"`
+		Port uint16 `validate:"min=1"`
+	}
+	type node struct {
+		Name     string
+		Children []node
+		Token    string `json:"-"`
+	}
+	type config struct {
+		AppName string        `help:"Application name" validate:"required,max=32"`
+		Mode    string        `default:"dev" validate:"oneof=dev|prod"`
+		Level   int           `validate:"oneof=1|2|3"`
+		Ratio   float64       `validate:"min=0,max=1"`
+		Timeout time.Duration `default:"5s"`
+		Tags    []string      `validate:"pattern=^[a-z]+$"`
+		Servers []server
+		Labels  map[string]string
+		Value1  int    `toml:"intVal"`
+		Secret  string `toml:"-"`
+		DB      *struct {
+			User string `json:"user_name"`
+		}
+		Tree     node
+		internal int
+	}
+	cfg := config{Ratio: 0.5}
+	var sb strings.Builder
+	if err := WriteConfigJSONSchema(&sb, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	var schema map[string]interface{}
+	if err := json.Unmarshal([]byte(sb.String()), &schema); err != nil {
+		t.Fatalf("WriteConfigJSONSchema wrote invalid JSON: %v\n%v", err, sb.String())
+	}
+	get := func(path ...string) interface{} {
+		var v interface{} = schema
+		for _, key := range path {
+			m, _ := v.(map[string]interface{})
+			v = m[key]
+		}
+		return v
+	}
+	tests := []struct {
+		path     []string
+		expected interface{}
+	}{
+		{[]string{"$schema"}, JSONSchemaDraft},
+		{[]string{"type"}, "object"},
+		{[]string{"required"}, []interface{}{"appname"}},
+		{[]string{"properties", "appname", "description"}, "Application name"},
+		{[]string{"properties", "appname", "maxLength"}, 32.0},
+		{[]string{"properties", "mode", "default"}, "dev"},
+		{[]string{"properties", "mode", "enum"}, []interface{}{"dev", "prod"}},
+		{[]string{"properties", "level", "enum"}, []interface{}{1.0, 2.0, 3.0}},
+		{[]string{"properties", "ratio", "type"}, "number"},
+		{[]string{"properties", "ratio", "default"}, 0.5},
+		{[]string{"properties", "ratio", "maximum"}, 1.0},
+		{[]string{"properties", "timeout", "default"}, "5s"},
+		{[]string{"properties", "tags", "type"}, "array"},
+		{[]string{"properties", "tags", "items", "pattern"}, "^[a-z]+$"},
+		{[]string{"properties", "servers", "items", "required"}, []interface{}{"host"}},
+		{[]string{"properties", "servers", "items", "properties", "port", "minimum"}, 1.0},
+		{[]string{"properties", "labels", "additionalProperties", "type"}, "string"},
+		{[]string{"properties", "intVal", "type"}, "integer"},
+		{[]string{"properties", "value1"}, nil},
+		{[]string{"properties", "tree", "properties", "children", "items", "$ref"}, "#/$defs/node"},
+		{[]string{"properties", "tree", "properties", "token"}, nil},
+		{[]string{"$defs", "node", "properties", "children", "items", "$ref"}, "#/$defs/node"},
+		{[]string{"$defs", "node", "properties", "name", "type"}, "string"},
+		{[]string{"properties", "secret"}, nil},
+		{[]string{"properties", "internal"}, nil},
+		{[]string{"properties", "db", "type"}, "object"},
+		{[]string{"properties", "db", "properties", "user_name", "type"}, "string"},
+		{[]string{"patternProperties", "^[-_ ]*[Aa][-_ ]*[Pp][-_ ]*[Pp][-_ ]*[Nn][-_ ]*[Aa][-_ ]*[Mm][-_ ]*[Ee][-_ ]*$", "$ref"},
+			"#/properties/appname"},
+		{[]string{"patternProperties", "^[-_ ]*[Vv][-_ ]*[Aa][-_ ]*[Ll][-_ ]*[Uu][-_ ]*[Ee][-_ ]*1[-_ ]*$", "$ref"},
+			"#/properties/intVal"},
+		{[]string{"properties", "db", "patternProperties", "^[-_ ]*[Uu][-_ ]*[Ss][-_ ]*[Ee][-_ ]*[Rr][-_ ]*[Nn][-_ ]*[Aa][-_ ]*[Mm][-_ ]*[Ee][-_ ]*$", "$ref"},
+			"#/properties/db/properties/user_name"},
+	}
+	for _, tt := range tests {
+		if v := get(tt.path...); !reflect.DeepEqual(v, tt.expected) {
+			t.Errorf("Schema %v = %#v, expected %#v", strings.Join(tt.path, "/"), v, tt.expected)
+		}
+	}
+	for pattern := range get("patternProperties").(map[string]interface{}) {
+		re := regexp.MustCompile(pattern)
+		if re.MatchString("app_name") != strings.Contains(pattern, "[Aa][-_ ]*[Pp]") {
+			t.Errorf("Pattern %v matches 'app_name': %v", pattern, re.MatchString("app_name"))
+		}
+	}
+	if _, err := GetConfigJSONSchema(cfg); err == nil {
+		t.Error("GetConfigJSONSchema accepted structure instead of pointer")
+	}
+}
+
+func TestConfigMigration(t *testing.T) {
+	type config struct {
+		ConfigVersion int           `toml:"config_version"`
+		LogLevel      string        `toml:"log_level" deprecated:"verbosity"`
+		Port          int           `deprecated:"server.port,move it out of [server] table"`
+		Timeout       time.Duration `toml:"timeout"`
+		DB            struct {
+			Host string `deprecated:"hostname"`
+		}
+	}
+	migrations := 0
+	toSeconds := func(m map[string]interface{}) error {
+		migrations++
+		if seconds, ok := m["timeout"].(int64); ok {
+			m["timeout"] = fmt.Sprintf("%vs", seconds)
+		}
+		return nil
+	}
+	content := "# app config\n" +
+		"verbosity = \"debug\"\n" +
+		"timeout = 30\n" +
+		"\n" +
+		"[server]\n" +
+		"port = 8080\n" +
+		"\n" +
+		"[db]\n" +
+		"hostname = \"example\" # database\n"
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var warnings strings.Builder
+	options := []LoaderOption{WithConfigFile(path), WithoutEnv(), WithStrict(true),
+		WithLogger(NewWriterLogger(nil, &warnings)), WithMigration(2, toSeconds)}
+	loader := NewLoader(options...)
+	for i := 0; i < 2; i++ {
+		var cfg config
+		if err := loader.Load(&cfg); err != nil {
+			t.Fatalf("Load of old config returned error: %v", err)
+		}
+		if cfg.ConfigVersion != 2 || cfg.LogLevel != "debug" || cfg.Port != 8080 || cfg.Timeout != 30*time.Second ||
+			cfg.DB.Host != "example" {
+			t.Errorf("Load of old config returned %+v", cfg)
+		}
+	}
+	if migrations != 2 {
+		t.Errorf("Config file is migrated %v times by 2 loads", migrations)
+	}
+	expected := fmt.Sprintf("Config file '%[1]v' of version 0 is migrated to version 2\n"+
+		"Config key 'db.hostname' of '%[1]v' is deprecated, use 'db.host'\n"+
+		"Config key 'server.port' of '%[1]v' is deprecated, move it out of [server] table\n"+
+		"Config key 'verbosity' of '%[1]v' is deprecated, use 'log_level'\n", path)
+	if warnings.String() != expected {
+		t.Errorf("Loader warned:\n%v\nexpected:\n%v", warnings.String(), expected)
+	}
+
+	var cfg config
+	reader := strings.NewReader("log_level = \"info\"\nverbosity = \"debug\"\n")
+	if err := NewLoader(WithReader("config.toml", reader), WithoutEnv()).Load(&cfg); err != nil ||
+		cfg.LogLevel != "info" {
+		t.Errorf("Deprecated key overrode the new key: %+v, %v", cfg, err)
+	}
+	failing := WithMigration(3, func(m map[string]interface{}) error { return errors.New("bad layout") })
+	if err := NewLoader(append(options, failing)...).Load(&cfg); err == nil ||
+		!strings.Contains(err.Error(), "migration to version 3 failed: bad layout") {
+		t.Errorf("Load with failing migration returned %v", err)
+	}
+
+	warnings.Reset()
+	cfg = config{}
+	migrations = 0
+	if err := NewLoader(append(options, WithMigrationRewrite(true))...).Load(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if migrations != 1 {
+		t.Errorf("Config file is migrated %v times by load with rewrite", migrations)
+	}
+	data, _ := os.ReadFile(path)
+	expected = "# app config\n" +
+		"log_level = \"debug\"\n" +
+		"timeout = \"30s\"\n" +
+		"config_version = 2\n" +
+		"port = 8080\n" +
+		"\n" +
+		"[db]\n" +
+		"host = \"example\" # database\n"
+	if string(data) != expected {
+		t.Errorf("Migrated config is rewritten as:\n%v\nexpected:\n%v", string(data), expected)
+	}
+	warnings.Reset()
+	var rewritten config
+	if err := NewLoader(options...).Load(&rewritten); err != nil || !reflect.DeepEqual(rewritten, cfg) ||
+		warnings.String() != "" {
+		t.Errorf("Load of rewritten config returned %+v, %v, warnings: %v", rewritten, err, warnings.String())
+	}
+}
+
+func TestLoadConfigLenient(t *testing.T) {
+	type server struct {
+		Host    string
+		Enabled bool
+	}
+	type config struct {
+		MaxConn int
+		Debug   bool
+		Ports   []int
+		Name    string `toml:"app_name"`
+		DB      struct {
+			ConnTimeout time.Duration
+			Started     time.Time
+		}
+		Servers []server
+		Zones   map[string]server
+		Secret  string `toml:"-"`
+		Token   string `json:"-"`
+	}
+	content := "max-conn = \"0x1F\"\n" +
+		"DEBUG = \"yes\"\n" +
+		"ports = \"80, 443\"\n" +
+		"AppName = \"app\"\n" +
+		"\n" +
+		"[Db]\n" +
+		"conn_timeout = \"5s\"\n" +
+		"started = 2019-10-27T18:42:09Z\n" +
+		"\n" +
+		"[[servers]]\n" +
+		"host = \"a\"\n" +
+		"enabled = \"on\"\n" +
+		"\n" +
+		"[zones.west]\n" +
+		"HOST = \"b\"\n"
+	var cfg config
+	if err := NewLoader(WithReader("config.toml", strings.NewReader(content)), WithoutEnv(), WithStrict(true),
+		WithLenient(true)).Load(&cfg); err != nil {
+		t.Fatalf("Lenient loading returned error: %v", err)
+	}
+	expected := config{MaxConn: 31, Debug: true, Ports: []int{80, 443}, Name: "app",
+		Servers: []server{{Host: "a", Enabled: true}}, Zones: map[string]server{"west": {Host: "b"}}}
+	expected.DB.ConnTimeout = 5 * time.Second
+	expected.DB.Started = time.Date(2019, 10, 27, 18, 42, 9, 0, time.UTC)
+	if !reflect.DeepEqual(cfg, expected) {
+		t.Errorf("Lenient loading returned\n%+v\nexpected\n%+v", cfg, expected)
+	}
+
+	if err := NewLoader(WithReader("config.toml", strings.NewReader(content)), WithoutEnv()).Load(&config{}); err == nil {
+		t.Error("Strict decoder accepted lenient values")
+	}
+	cfg = config{Name: "keep"}
+	yaml := "max_conn: 5\napp_name: ~\n"
+	if err := NewLoader(WithReader("config.yaml", strings.NewReader(yaml)), WithoutEnv(),
+		WithLenient(true)).Load(&cfg); err != nil || cfg.MaxConn != 5 || cfg.Name != "keep" {
+		t.Errorf("Lenient loading of YAML returned %+v, %v", cfg, err)
+	}
+	err := NewLoader(WithReader("config.toml", strings.NewReader("max_conn = \"many\"\n")), WithoutEnv(),
+		WithLenient(true)).Load(&cfg)
+	if err == nil || !strings.Contains(err.Error(), "Can't set field 'MaxConn'") {
+		t.Errorf("Lenient loading of invalid value returned %v", err)
+	}
+	cfg = config{}
+	err = NewLoader(WithReader("config.toml", strings.NewReader("secret = \"leak\"\ntoken = \"leak\"\n")), WithoutEnv(),
+		WithLenient(true)).Load(&cfg)
+	if err != nil || cfg.Secret != "" || cfg.Token != "" {
+		t.Errorf("Lenient loading set excluded fields: %+v, %v", cfg, err)
+	}
+	cfg = config{}
+	dotEnv := "MAX_CONN=0x1F\nDB_CONN_TIMEOUT=5s\n"
+	if err := NewLoader(WithReader("config.env", strings.NewReader(dotEnv)), WithoutEnv(),
+		WithLenient(true)).Load(&cfg); err != nil || cfg.MaxConn != 31 || cfg.DB.ConnTimeout != 5*time.Second {
+		t.Errorf("Lenient loading of dotenv returned %+v, %v", cfg, err)
+	}
+}
+
+func TestConfigEntries(t *testing.T) {
+	type deviceConfig struct {
+		Address string `validate:"required"`
+		Timeout time.Duration
+		Retries int `default:"3"`
+		Tags    []string
+	}
+	type config struct {
+		Device  map[string]deviceConfig  `template:""`
+		Sensors map[string]*deviceConfig `template:"common"`
+		Uplinks []deviceConfig
+	}
+	content := "[device.default]\n" +
+		"timeout = \"5s\"\n" +
+		"tags = [\"lan\"]\n" +
+		"\n" +
+		"[device.camera]\n" +
+		"address = \"10.0.0.2\"\n" +
+		"\n" +
+		"[device.door]\n" +
+		"address = \"10.0.0.3\"\n" +
+		"timeout = \"1s\"\n" +
+		"retries = 5\n" +
+		"\n" +
+		"[sensors.common]\n" +
+		"retries = 1\n" +
+		"\n" +
+		"[sensors.hall]\n" +
+		"address = \"10.0.1.1\"\n" +
+		"\n" +
+		"[[uplinks]]\n" +
+		"address = \"10.1.0.1\"\n"
+	expected := config{
+		Device: map[string]deviceConfig{
+			"camera": {Address: "10.0.0.2", Timeout: 5 * time.Second, Retries: 3, Tags: []string{"lan"}},
+			"door":   {Address: "10.0.0.3", Timeout: time.Second, Retries: 5, Tags: []string{"lan"}},
+		},
+		Sensors: map[string]*deviceConfig{"hall": {Address: "10.0.1.1", Retries: 1}},
+		Uplinks: []deviceConfig{{Address: "10.1.0.1", Retries: 3}},
+	}
+	for _, lenient := range []bool{false, true} {
+		var cfg config
+		err := NewLoader(WithReader("config.toml", strings.NewReader(content)), WithoutEnv(), WithStrict(true),
+			WithLenient(lenient)).Load(&cfg)
+		if err != nil || !reflect.DeepEqual(cfg, expected) {
+			t.Errorf("Load (lenient: %v) returned %+v, %v", lenient, cfg, err)
+		}
+		cfg.Device["camera"].Tags[0] = "wan"
+		if cfg.Device["door"].Tags[0] != "lan" {
+			t.Error("The entries share the values of template")
+		}
+	}
+
+	var cfg config
+	m := map[string]interface{}{
+		"device": map[string]interface{}{
+			"default": map[string]interface{}{"retries": 7},
+			"camera":  map[string]interface{}{"address": "10.0.0.2"},
+			"door":    map[string]interface{}{"timeout": "1s"},
+		},
+	}
+	_, err := ParseMapToStruct(m, &cfg)
+	if err == nil || err.Error() != "Field 'Device[door].Address' is required" {
+		t.Errorf("ParseMapToStruct returned error: %v", err)
+	}
+	if cfg.Device["camera"].Retries != 7 || len(cfg.Device) != 2 {
+		t.Errorf("ParseMapToStruct returned %+v", cfg)
+	}
+	if _, err = ParseMapToStruct(map[string]interface{}{"device": map[string]interface{}{
+		"gate": map[string]interface{}{"address": "10.0.0.4"}}}, &cfg); err == nil || len(cfg.Device) != 3 {
+		t.Errorf("ParseMapToStruct did not add the entry to existing map: %+v, %v", cfg, err)
+	}
+
+	err = NewLoader(WithReader("config.toml", strings.NewReader("[device.door]\ntimeout = \"1s\"\n")),
+		WithoutEnv()).Load(&config{})
+	var fieldErr *FieldError
+	if !errors.As(err, &fieldErr) || fieldErr.Field != "Device[door].Address" ||
+		!strings.Contains(err.Error(), "config.toml") {
+		t.Errorf("Load of invalid entry returned %v", err)
+	}
+
+	// the explicit zero values of entry override the template, the template applies to the sources loaded later
+	type node struct {
+		Enabled bool
+		Retries int
+		Name    string
+	}
+	type nodesConfig struct {
+		Nodes map[string]node `template:""`
+		Plain map[string]node
+	}
+	content = "[nodes.default]\nenabled = true\nretries = 3\nname = \"node\"\n" +
+		"\n" +
+		"[nodes.a]\nenabled = false\nretries = 0\nname = \"\"\n" +
+		"\n" +
+		"[plain.default]\nretries = 1\n"
+	expectedNodes := nodesConfig{
+		Nodes: map[string]node{"a": {}, "b": {Enabled: true, Retries: 5, Name: "node"}},
+		Plain: map[string]node{"default": {Retries: 1}},
+	}
+	for _, lenient := range []bool{false, true} {
+		var nodes nodesConfig
+		err := NewLoader(WithReader("config.toml", strings.NewReader(content)),
+			WithReader("override.toml", strings.NewReader("[nodes.b]\nretries = 5\n")),
+			WithoutEnv(), WithStrict(true), WithLenient(lenient)).Load(&nodes)
+		if err != nil || !reflect.DeepEqual(nodes, expectedNodes) {
+			t.Errorf("Load (lenient: %v) returned %+v, %v; expected: %+v", lenient, nodes, err, expectedNodes)
+		}
+	}
+
+	// the explicit zero values of entry override its `default` tags
+	type endpoint struct {
+		Enabled bool `default:"true"`
+		Port    int  `default:"80"`
+	}
+	type endpointsConfig struct {
+		Device map[string]endpoint
+		Server []endpoint
+	}
+	endpointsContent := "[device.cam]\nenabled = false\nport = 0\n" +
+		"\n" +
+		"[device.door]\n" +
+		"\n" +
+		"[[server]]\nenabled = false\n"
+	expectedEndpoints := endpointsConfig{
+		Device: map[string]endpoint{"cam": {}, "door": {Enabled: true, Port: 80}},
+		Server: []endpoint{{Port: 80}},
+	}
+	for _, lenient := range []bool{false, true} {
+		var endpoints endpointsConfig
+		err := NewLoader(WithReader("config.toml", strings.NewReader(endpointsContent)), WithoutEnv(),
+			WithStrict(true), WithLenient(lenient)).Load(&endpoints)
+		if err != nil || !reflect.DeepEqual(endpoints, expectedEndpoints) {
+			t.Errorf("Load (lenient: %v) returned %+v, %v; expected: %+v", lenient, endpoints, err, expectedEndpoints)
+		}
+	}
+	var endpoints endpointsConfig
+	_, err = ParseMapToStruct(map[string]interface{}{"server": []interface{}{map[string]interface{}{"enabled": false}}},
+		&endpoints)
+	if err != nil || fmt.Sprint(endpoints.Server) != "[{false 80}]" {
+		t.Errorf("ParseMapToStruct returned %+v, %v", endpoints, err)
+	}
+
+	// SaveConfig keeps the template and does not write the values taken from it
+	path := filepath.Join(t.TempDir(), "nodes.toml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var nodes nodesConfig
+	if err := NewLoader(WithConfigFile(path), WithoutEnv(), WithStrict(true)).Load(&nodes); err != nil {
+		t.Fatal(err)
+	}
+	nodes.Nodes["a"] = node{Retries: 1}
+	if err := SaveConfig(path, &nodes); err != nil {
+		t.Fatalf("SaveConfig returned error: %v", err)
+	}
+	saved := "[nodes.default]\nenabled = true\nretries = 3\nname = \"node\"\n" +
+		"\n" +
+		"[nodes.a]\nenabled = false\nretries = 1\nname = \"\"\n" +
+		"\n" +
+		"[plain.default]\nretries = 1\n"
+	data, _ := os.ReadFile(path)
+	if string(data) != saved {
+		t.Errorf("SaveConfig wrote:\n%v\nexpected:\n%v", string(data), saved)
+	}
+}